@@ -12,18 +12,28 @@ import (
 
 	"github.com/Jdemon/ktel/config"
 	"github.com/Jdemon/ktel/consumer"
+	"github.com/Jdemon/ktel/dedup"
+	"github.com/Jdemon/ktel/dlq"
 	"github.com/Jdemon/ktel/health"
 	internalkgo "github.com/Jdemon/ktel/kgo"
 	"github.com/Jdemon/ktel/logger"
 	"github.com/Jdemon/ktel/otel"
 	"github.com/Jdemon/ktel/processor"
+	"github.com/Jdemon/ktel/producer"
+	"github.com/Jdemon/ktel/retry"
+	"github.com/Jdemon/ktel/retrytopic"
+	"github.com/Jdemon/ktel/serde"
 	"github.com/Jdemon/ktel/telemetry"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"go.opentelemetry.io/otel/sdk/metric"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// errReplayDone stops the application once a replay has consumed its ranges.
+var errReplayDone = errors.New("replay completed")
+
 type app struct {
 	Cfg            *config.Config
 	Logger         *zap.SugaredLogger
@@ -31,69 +41,241 @@ type app struct {
 	HealthChecker  *health.Checker
 	TracerProvider *sdktrace.TracerProvider
 	MeterProvider  *metric.MeterProvider
+	SchemaRegistry *serde.Registry
+	// Producer produces records with trace context, correlation ids and metrics. It is also the
+	// producer processors get from processor.ProducerFromContext.
+	Producer *producer.Producer
+	// Middlewares wrap the processor passed to Start, outermost first. It starts out with the
+	// instrumentation middleware; append with Use, or reassign it to control the order fully.
+	Middlewares []processor.Middleware
+
+	offsets      *consumer.OffsetTracker
+	replay       map[string]map[int32]consumer.OffsetRange
+	session      *kgo.GroupTransactSession
+	instrumentor *telemetry.Instrumentor
+	settings     any
 }
 
-func New() (*app, error) {
+// New creates the application. Without options, the configuration is loaded with config.New and
+// the logger, OpenTelemetry providers and Kafka client are built from it.
+func New(opts ...Option) (*app, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Load configuration
-	cfg, err := config.New()
+	cfg, err := loadConfig(o)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
 
 	// Initialize logger
-	if err = logger.New(cfg.AppName); err != nil {
+	if o.logger != nil {
+		zap.ReplaceGlobals(o.logger)
+	} else if err = logger.New(cfg.AppName); err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
 
-	tp, mp, err := otel.InitOtelProviders(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize OpenTelemetry providers: %w", err)
+	effective := any(cfg)
+	if o.settings != nil {
+		effective = o.settings
+	}
+	if text, err := config.Effective(effective); err == nil {
+		zap.S().Debugf("Effective configuration:\n%s", text)
 	}
 
-	healthChecker := health.NewChecker()
+	// Providers that were not passed in are created from the otel configuration.
+	tp, mp := o.tracerProvider, o.meterProvider
+	if tp == nil && mp == nil {
+		if tp, mp, err = otel.InitOtelProviders(cfg); err != nil {
+			return nil, fmt.Errorf("failed to initialize OpenTelemetry providers: %w", err)
+		}
+	} else {
+		if tp == nil {
+			if tp, err = otel.NewTracerProvider(cfg); err != nil {
+				return nil, fmt.Errorf("failed to initialize OpenTelemetry tracer provider: %w", err)
+			}
+		}
+		if mp == nil {
+			if mp, err = otel.NewMeterProvider(cfg); err != nil {
+				return nil, fmt.Errorf("failed to initialize OpenTelemetry meter provider: %w", err)
+			}
+		}
+		otel.SetProviders(tp, mp)
+	}
 
-	kgoOptions := internalkgo.BuildKgoOptions(cfg, tp, healthChecker)
-	kafkaClient, err := kgo.NewClient(kgoOptions...)
+	instrumentor, err := telemetry.NewInstrumentor()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+		return nil, fmt.Errorf("failed to create telemetry instrumentor: %w", err)
+	}
+
+	healthChecker := o.healthChecker
+	if healthChecker == nil {
+		healthChecker = health.NewChecker()
+	}
+
+	// Transactions commit the consumed offsets themselves, and replays commit none.
+	var offsets *consumer.OffsetTracker
+	if !cfg.Kafka.Consumer.AutoCommit && !cfg.Kafka.Transactions.Enabled && !cfg.Kafka.Replay.Enabled {
+		offsets = consumer.NewOffsetTracker()
+	}
+
+	offsetsCtx, cancelOffsets := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelOffsets()
+	var kgoOptions []kgo.Opt
+	var replay map[string]map[int32]consumer.OffsetRange
+	if cfg.Kafka.Replay.Enabled {
+		if replay, err = internalkgo.ReplayRanges(offsetsCtx, cfg, o.kgoOpts...); err != nil {
+			return nil, fmt.Errorf("failed to resolve replay ranges: %w", err)
+		}
+		kgoOptions = internalkgo.BuildReplayKgoOptions(cfg, tp, replay)
+		// Without a group, no partitions are ever assigned.
+		healthChecker.SetReady(true)
+	} else {
+		// Start offsets are committed before the group is joined by the client below.
+		if err = internalkgo.PrepareGroupOffsets(offsetsCtx, cfg, o.kgoOpts...); err != nil {
+			return nil, fmt.Errorf("failed to prepare consumer group offsets: %w", err)
+		}
+		kgoOptions = internalkgo.BuildKgoOptions(cfg, tp, healthChecker, offsets)
+	}
+	kgoOptions = append(kgoOptions, o.kgoOpts...)
+
+	var session *kgo.GroupTransactSession
+	var kafkaClient *kgo.Client
+	if cfg.Kafka.Transactions.Enabled {
+		session, err = kgo.NewGroupTransactSession(kgoOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kafka transact session: %w", err)
+		}
+		kafkaClient = session.Client()
+	} else {
+		kafkaClient, err = kgo.NewClient(kgoOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+		}
+	}
+
+	var schemaRegistry *serde.Registry
+	if cfg.SchemaRegistry.URL != "" {
+		schemaRegistry = serde.NewRegistry(cfg.SchemaRegistry.URL,
+			serde.WithBasicAuthFunc(cfg.SchemaRegistry.Username, func(ctx context.Context) (string, error) {
+				return schemaRegistryPassword(ctx, cfg)
+			}),
+			serde.WithTimeout(cfg.SchemaRegistry.Timeout),
+		)
 	}
 
-	return &app{
+	a := &app{
 		Cfg:            cfg,
 		Logger:         zap.S(),
 		KafkaClient:    kafkaClient,
 		HealthChecker:  healthChecker,
 		TracerProvider: tp,
 		MeterProvider:  mp,
-	}, nil
+		SchemaRegistry: schemaRegistry,
+		offsets:        offsets,
+		replay:         replay,
+		session:        session,
+		instrumentor:   instrumentor,
+		settings:       o.settings,
+	}
+	a.Producer = producer.New(kafkaClient, instrumentor, a.tracer())
+	a.Middlewares = []processor.Middleware{a.Instrumentation()}
+
+	return a, nil
+}
+
+// NewWithConfig creates the application like New, with an application configuration of type T
+// that embeds config.Config, loaded with config.Load or, given WithConfigFile, config.LoadFile.
+// The embedded Config configures the application, replacing any WithConfig option. Processors
+// get the returned settings from config.SettingsFromContext.
+func NewWithConfig[T any, PT config.Embedder[T]](opts ...Option) (*app, *T, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var settings *T
+	var err error
+	if o.configFile != "" {
+		settings, err = config.LoadFile[T, PT](o.configFile)
+	} else {
+		settings, err = config.Load[T, PT]()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	a, err := New(append(opts, WithConfig(PT(settings).Base()), withSettings(settings))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, settings, nil
+}
+
+// loadConfig returns the configuration given in o, or loads it from the configured file or the
+// default locations.
+func loadConfig(o options) (*config.Config, error) {
+	switch {
+	case o.cfg != nil:
+		if err := o.cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return o.cfg, nil
+	case o.configFile != "":
+		return config.NewFromFile(o.configFile)
+	default:
+		return config.New()
+	}
 }
 
 func (a *app) Start(proc processor.Processor, cleanupFns ...func()) error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	// A consumer that stops on its own cancels ctx with its error as the cause.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	var wg sync.WaitGroup
+	var wg, consumerWg sync.WaitGroup
 
 	// Start health check server
 	httpServer := a.startHealthCheckServer(ctx, &wg)
 
 	// Start Kafka consumer
-	if err := a.startConsumer(ctx, &wg, proc); err != nil {
+	if err := a.startConsumer(ctx, cancel, &consumerWg, proc); err != nil {
 		return err
 	}
 
-	// Wait for termination signal
+	// Wait for termination signal or consumer failure
 	<-ctx.Done()
-	a.Logger.Info("Termination signal received, initiating graceful shutdown...")
+	consumerErr := context.Cause(ctx)
+	switch {
+	case errors.Is(consumerErr, context.Canceled):
+		consumerErr = nil
+		a.Logger.Info("Termination signal received, initiating graceful shutdown...")
+	case errors.Is(consumerErr, errReplayDone):
+		consumerErr = nil
+		a.Logger.Info("Replay completed, initiating graceful shutdown...")
+	default:
+		a.Logger.Errorw("Kafka consumer failed, initiating graceful shutdown...", "error", consumerErr)
+	}
 
 	// Shutdown HTTP server
 	a.shutdownHTTPServer(httpServer)
 
+	// Wait for in-flight records and the final offset commit
+	consumerWg.Wait()
+
 	// Shutdown OpenTelemetry providers
 	a.shutdownOtelProviders()
 
-	// Close Kafka client
-	a.KafkaClient.Close()
+	// Close Kafka client, aborting an unfinished transaction
+	if a.session != nil {
+		a.session.Close()
+	} else {
+		a.KafkaClient.Close()
+	}
 
 	for _, fn := range cleanupFns {
 		fn()
@@ -104,7 +286,48 @@ func (a *app) Start(proc processor.Processor, cleanupFns ...func()) error {
 	wg.Wait()
 
 	a.Logger.Debug("All services shut down gracefully.")
-	return nil
+	return consumerErr
+}
+
+// Use appends middlewares to the chain. They run inside the middlewares registered before them.
+func (a *app) Use(middlewares ...processor.Middleware) {
+	a.Middlewares = append(a.Middlewares, middlewares...)
+}
+
+// Instrumentation returns the middleware that traces and measures every record.
+// It is registered by default.
+func (a *app) Instrumentation() processor.Middleware {
+	return processor.Instrument(a.instrumentor, a.tracer())
+}
+
+// Dedup returns a middleware that skips records whose key, as returned by extract, was already
+// processed within ttl, see dedup.Middleware. Skipped duplicates are counted in the app metrics.
+func (a *app) Dedup(store dedup.Store, extract dedup.KeyExtractor, ttl time.Duration) processor.Middleware {
+	return dedup.Middleware(store, extract, ttl, a.instrumentor)
+}
+
+// NewRouter creates a processor.Router that handles unmatched topics as configured in kafka.unmatchedTopic.
+func (a *app) NewRouter() *processor.Router {
+	return processor.NewRouter(processor.UnmatchedPolicy(a.Cfg.Kafka.UnmatchedTopic))
+}
+
+// NewDispatcher creates a processor.Dispatcher like processor.NewDispatcher. The dlq policy is
+// rejected unless kafka.deadLetter or kafka.retryTopics is enabled, since records of unknown
+// types would otherwise stop their partition instead of reaching a dead-letter topic.
+func (a *app) NewDispatcher(extract processor.EventTypeExtractor, unknown processor.UnmatchedPolicy) (*processor.Dispatcher, error) {
+	if unknown == processor.UnmatchedDLQ && !a.Cfg.DeadLettering() {
+		return nil, errors.New("unknown event type policy dlq requires kafka.deadLetter or kafka.retryTopics to be enabled")
+	}
+	return processor.NewDispatcher(extract, unknown), nil
+}
+
+// StartBatch starts the application like Start, processing records in batches with bp.
+// Batches are flushed according to kafka.consumer.batch, and every batch is traced with
+// links to the producer spans of its records.
+func (a *app) StartBatch(bp processor.BatchProcessor, cleanupFns ...func()) error {
+	instrumentedBatchProc := processor.NewInstrumentingBatchProcessor(bp, a.instrumentor, a.tracer())
+	batchingProc := processor.NewBatchingProcessor(instrumentedBatchProc, a.Cfg.Kafka.Consumer.Batch.MaxSize, a.Cfg.Kafka.Consumer.Batch.MaxLinger)
+	return a.Start(batchingProc, cleanupFns...)
 }
 
 func (a *app) startHealthCheckServer(_ context.Context, wg *sync.WaitGroup) *http.Server {
@@ -130,27 +353,76 @@ func (a *app) startHealthCheckServer(_ context.Context, wg *sync.WaitGroup) *htt
 	return server
 }
 
-func (a *app) startConsumer(ctx context.Context, wg *sync.WaitGroup, proc processor.Processor) error {
-	instrumentor, err := telemetry.NewInstrumentor()
-	if err != nil {
-		return fmt.Errorf("failed to create telemetry instrumentor: %w", err)
+func (a *app) startConsumer(ctx context.Context, cancel context.CancelCauseFunc, wg *sync.WaitGroup, proc processor.Processor) error {
+	chainedProc := processor.Chain(proc, a.Middlewares...)
+	if a.settings != nil {
+		chainedProc = settingsProcessor(chainedProc, a.settings)
+	}
+	consumerOpts := []consumer.Option{
+		consumer.WithMode(consumer.Mode(a.Cfg.Kafka.Consumer.Mode)),
+		consumer.WithLanes(a.Cfg.Kafka.Consumer.Lanes),
+		consumer.WithMaxConcurrency(a.Cfg.Kafka.Consumer.MaxConcurrency),
+		consumer.WithMaxInFlightBytes(a.Cfg.Kafka.Consumer.MaxInFlightBytes),
+		consumer.WithMaxInFlightPerPartition(a.Cfg.Kafka.Consumer.MaxInFlightPerPartition),
+		consumer.WithMaxPendingPerPartition(a.Cfg.Kafka.Consumer.MaxPendingPerPartition),
+		consumer.WithInstrumentor(a.instrumentor),
+		consumer.WithProducer(a.Producer),
+		consumer.WithOffsetTracker(a.offsets),
+		consumer.WithBounds(a.replay),
+		consumer.WithCommitInterval(a.Cfg.Kafka.Consumer.CommitInterval),
+		consumer.WithRetryPolicy(retry.Policy{
+			MaxAttempts:    a.Cfg.Kafka.Consumer.Retry.MaxAttempts,
+			InitialBackoff: a.Cfg.Kafka.Consumer.Retry.InitialBackoff,
+			MaxBackoff:     a.Cfg.Kafka.Consumer.Retry.MaxBackoff,
+			Multiplier:     a.Cfg.Kafka.Consumer.Retry.Multiplier,
+			Jitter:         a.Cfg.Kafka.Consumer.Retry.Jitter,
+		}),
+	}
+
+	if a.Cfg.DeadLettering() {
+		publisher := dlq.NewPublisher(a.KafkaClient, a.Cfg.Kafka.DeadLetter.Topic, a.Cfg.Kafka.GroupID, a.instrumentor)
+		var failureHandler consumer.FailureHandler = publisher
+		if a.Cfg.Kafka.RetryTopics.Enabled {
+			tiers := retrytopic.Tiers(a.Cfg.BaseTopic(), a.Cfg.Kafka.GroupID, a.Cfg.Kafka.RetryTopics.Delays)
+			failureHandler = retrytopic.NewRouter(a.KafkaClient, tiers, publisher, a.instrumentor)
+		}
+		consumerOpts = append(consumerOpts, consumer.WithFailureHandler(failureHandler))
 	}
 
-	clientAdapter := &consumer.KgoClientAdapter{Client: a.KafkaClient}
-	instrumentedProc := processor.NewInstrumentingProcessor(proc, instrumentor, a.TracerProvider.Tracer(a.Cfg.AppName))
-	appConsumer := consumer.New(clientAdapter, instrumentedProc, a.Logger)
+	// Records produced through the session client, including dead-lettered and retried ones,
+	// join the transaction of the batch being processed.
+	var clientAdapter consumer.KafkaClient = &consumer.KgoClientAdapter{Client: a.KafkaClient}
+	if a.session != nil {
+		sessionAdapter := &consumer.KgoTransactSessionAdapter{Session: a.session}
+		clientAdapter = sessionAdapter
+		consumerOpts = append(consumerOpts, consumer.WithTransactions(sessionAdapter))
+	}
+	appConsumer := consumer.New(clientAdapter, chainedProc, a.Logger, consumerOpts...)
+	a.HealthChecker.AddReadinessCheck("kafka-consumer", appConsumer.Err)
 
 	a.Logger.Debug("Kafka consumer started...")
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		appConsumer.Run(ctx)
+		if err := appConsumer.Run(ctx); err != nil {
+			cancel(fmt.Errorf("kafka consumer stopped: %w", err))
+		} else if a.replay != nil && ctx.Err() == nil {
+			cancel(errReplayDone)
+		}
 	}()
 
 	return nil
 }
 
+// tracer returns the application tracer, falling back to the global one when OpenTelemetry is disabled.
+func (a *app) tracer() trace.Tracer {
+	if a.TracerProvider == nil {
+		return telemetry.Tracer()
+	}
+	return a.TracerProvider.Tracer(a.Cfg.AppName)
+}
+
 func (a *app) shutdownHTTPServer(server *http.Server) {
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
@@ -174,3 +446,25 @@ func (a *app) shutdownOtelProviders() {
 		}
 	}
 }
+
+// settingsProcessor puts the application configuration settings into the context of every
+// record before next sees it.
+func settingsProcessor(next processor.Processor, settings any) processor.Processor {
+	return processor.ProcessorFunc(func(ctx context.Context, record *kgo.Record) error {
+		return next.ProcessRecord(config.ContextWithSettings(ctx, settings), record)
+	})
+}
+
+// schemaRegistryPassword returns the current Schema Registry password, resolving its secret
+// reference again if it was configured as one.
+func schemaRegistryPassword(ctx context.Context, cfg *config.Config) (string, error) {
+	pass, ok, err := cfg.ResolveSecret(ctx, "schemaRegistry.password")
+	if err != nil {
+		zap.S().Errorw("Failed to resolve Schema Registry password", "error", err)
+		return "", err
+	}
+	if !ok {
+		return cfg.SchemaRegistry.Password, nil
+	}
+	return pass, nil
+}
@@ -3,9 +3,10 @@ package config
 import (
 	"errors"
 	"fmt"
-	"strings"
+	"os"
+	"regexp"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
 )
 
@@ -13,10 +14,13 @@ import (
 type Config struct {
 	AppName string `mapstructure:"appName" validate:"required"`
 	Kafka   struct {
-		Brokers           string `mapstructure:"brokers" validate:"required"`
-		Topic             string `mapstructure:"topic" validate:"required"`
-		GroupID           string `mapstructure:"groupId" validate:"required"`
-		RebalanceStrategy string `mapstructure:"rebalanceStrategy"`
+		Brokers           string   `mapstructure:"brokers" validate:"required"`
+		Topic             string   `mapstructure:"topic"`
+		Topics            []string `mapstructure:"topics"`
+		TopicPatterns     []string `mapstructure:"topicPatterns" validate:"dive,required"`
+		UnmatchedTopic    string   `mapstructure:"unmatchedTopic" validate:"oneof=skip error dlq"`
+		GroupID           string   `mapstructure:"groupId" validate:"required"`
+		RebalanceStrategy string   `mapstructure:"rebalanceStrategy"`
 		TLS               struct {
 			Enabled  bool   `mapstructure:"enabled"`
 			CAFile   string `mapstructure:"caFile"`
@@ -27,9 +31,87 @@ type Config struct {
 			Enabled   bool   `mapstructure:"enabled"`
 			Mechanism string `mapstructure:"mechanism"`
 			Username  string `mapstructure:"username"`
-			Password  string `mapstructure:"password"`
+			Password  string `mapstructure:"password" secret:"true"`
+			// PasswordFile is read for the password instead, on every new connection.
+			PasswordFile string `mapstructure:"passwordFile"`
 		} `mapstructure:"sasl"`
+		Offsets struct {
+			Reset                 string `mapstructure:"reset" validate:"oneof=earliest latest timestamp"`
+			Timestamp             string `mapstructure:"timestamp" validate:"required_if=Reset timestamp,required_if=ResetGroupToTimestamp true"`
+			ResetGroupToTimestamp bool   `mapstructure:"resetGroupToTimestamp"`
+			Partitions            []struct {
+				Topic     string `mapstructure:"topic" validate:"required"`
+				Partition int32  `mapstructure:"partition" validate:"gte=0"`
+				Offset    int64  `mapstructure:"offset" validate:"gte=0"`
+			} `mapstructure:"partitions" validate:"dive"`
+		} `mapstructure:"offsets"`
+		Replay struct {
+			Enabled    bool   `mapstructure:"enabled"`
+			From       string `mapstructure:"from"`
+			To         string `mapstructure:"to"`
+			Partitions []struct {
+				Topic     string `mapstructure:"topic" validate:"required"`
+				Partition int32  `mapstructure:"partition" validate:"gte=0"`
+				Start     int64  `mapstructure:"start" validate:"gte=0"`
+				End       int64  `mapstructure:"end" validate:"gtfield=Start"`
+			} `mapstructure:"partitions" validate:"dive"`
+		} `mapstructure:"replay"`
+		Consumer struct {
+			Mode                    string        `mapstructure:"mode" validate:"oneof=concurrent partition key"`
+			Lanes                   int           `mapstructure:"lanes" validate:"gte=1"`
+			MaxConcurrency          int           `mapstructure:"maxConcurrency" validate:"gte=0"`
+			MaxInFlightBytes        int64         `mapstructure:"maxInFlightBytes" validate:"gte=0"`
+			MaxInFlightPerPartition int           `mapstructure:"maxInFlightPerPartition" validate:"gte=0"`
+			MaxPendingPerPartition  int           `mapstructure:"maxPendingPerPartition" validate:"gte=0"`
+			AutoCommit              bool          `mapstructure:"autoCommit"`
+			CommitInterval          time.Duration `mapstructure:"commitInterval" validate:"gt=0"`
+			Retry                   struct {
+				MaxAttempts    int           `mapstructure:"maxAttempts" validate:"gte=1"`
+				InitialBackoff time.Duration `mapstructure:"initialBackoff" validate:"gte=0"`
+				MaxBackoff     time.Duration `mapstructure:"maxBackoff" validate:"gte=0"`
+				Multiplier     float64       `mapstructure:"multiplier" validate:"gte=1"`
+				Jitter         float64       `mapstructure:"jitter" validate:"gte=0,lte=1"`
+			} `mapstructure:"retry"`
+			Batch struct {
+				MaxSize   int           `mapstructure:"maxSize" validate:"gte=1"`
+				MaxLinger time.Duration `mapstructure:"maxLinger" validate:"gt=0"`
+			} `mapstructure:"batch"`
+		} `mapstructure:"consumer"`
+		Producer struct {
+			Acks            string        `mapstructure:"acks" validate:"oneof=all leader none"`
+			Idempotent      bool          `mapstructure:"idempotent"`
+			Linger          time.Duration `mapstructure:"linger" validate:"gte=0"`
+			Compression     string        `mapstructure:"compression" validate:"oneof=none gzip snappy lz4 zstd"`
+			MaxMessageBytes int32         `mapstructure:"maxMessageBytes" validate:"gt=0"`
+		} `mapstructure:"producer"`
+		DeadLetter struct {
+			Enabled bool   `mapstructure:"enabled"`
+			Topic   string `mapstructure:"topic"`
+		} `mapstructure:"deadLetter"`
+		RetryTopics struct {
+			Enabled bool            `mapstructure:"enabled"`
+			Delays  []time.Duration `mapstructure:"delays" validate:"required_if=Enabled true,dive,gt=0"`
+		} `mapstructure:"retryTopics"`
+		Transactions struct {
+			Enabled         bool          `mapstructure:"enabled"`
+			TransactionalID string        `mapstructure:"transactionalId"`
+			Timeout         time.Duration `mapstructure:"timeout" validate:"gt=0"`
+		} `mapstructure:"transactions"`
 	} `mapstructure:"kafka"`
+	SchemaRegistry struct {
+		URL          string        `mapstructure:"url" validate:"omitempty,url"`
+		Username     string        `mapstructure:"username"`
+		Password     string        `mapstructure:"password" secret:"true"`
+		PasswordFile string        `mapstructure:"passwordFile"`
+		Timeout      time.Duration `mapstructure:"timeout" validate:"gt=0"`
+	} `mapstructure:"schemaRegistry"`
+	Secrets struct {
+		// EncryptedFile configures the provider of secret:encrypted:<name> references.
+		EncryptedFile struct {
+			Path    string `mapstructure:"path"`
+			KeyFile string `mapstructure:"keyFile" validate:"required_with=Path"`
+		} `mapstructure:"encryptedFile"`
+	} `mapstructure:"secrets"`
 	Server struct {
 		Port string `mapstructure:"port" validate:"required"`
 	} `mapstructure:"server"`
@@ -41,44 +123,200 @@ type Config struct {
 			} `mapstructure:"grpc"`
 		} `mapstructure:"exporter"`
 	} `mapstructure:"otel"`
+
+	// secretRefs holds the secret references resolved at load, by key.
+	secretRefs map[string]string
 }
 
 // New creates a new Config struct and loads configuration from a file and environment variables.
+// The file is ktel-config.yaml, .yml, .toml or .json in the working directory or /app, or the
+// file named by KTEL_CONFIG. KTEL_PROFILE layers a profile's file over it, and ${NAME} references
+// in either file are replaced with environment variables, see Load.
 func New() (*Config, error) {
+	return Load[Config]()
+}
+
+// NewFromFile creates a new Config struct and loads configuration from the file at path, whose
+// format follows its extension, its profile overlay and environment variables. Unlike New, the
+// file must exist.
+func NewFromFile(path string) (*Config, error) {
+	return LoadFile[Config](path)
+}
+
+// Default returns a Config holding the default values only, without reading any file or
+// environment variable. It is not valid until at least the brokers and a topic are set.
+func Default() *Config {
+	var cfg Config
+	// Decoding the defaults cannot fail.
+	_ = newViper().Unmarshal(&cfg)
+	return &cfg
+}
+
+// newViper returns a viper instance with the default values.
+func newViper() *viper.Viper {
 	v := viper.New()
 
 	// Set default values
 	v.SetDefault("server.port", "8080")
 	v.SetDefault("appName", "kafka-consumer")
 	v.SetDefault("kafka.groupId", "kafka-consumer-group")
+	v.SetDefault("kafka.topics", []string{})
+	v.SetDefault("kafka.topicPatterns", []string{})
+	v.SetDefault("kafka.unmatchedTopic", "skip")
+	v.SetDefault("kafka.offsets.reset", "earliest")
+	v.SetDefault("kafka.offsets.timestamp", "")
+	v.SetDefault("kafka.offsets.resetGroupToTimestamp", false)
+	v.SetDefault("kafka.replay.enabled", false)
+	v.SetDefault("kafka.replay.from", "")
+	v.SetDefault("kafka.replay.to", "")
+	v.SetDefault("kafka.consumer.mode", "concurrent")
+	v.SetDefault("kafka.consumer.lanes", 16)
+	v.SetDefault("kafka.consumer.maxConcurrency", 100)
+	v.SetDefault("kafka.consumer.maxInFlightBytes", 1024*1024*50)
+	v.SetDefault("kafka.consumer.maxInFlightPerPartition", 50)
+	v.SetDefault("kafka.consumer.maxPendingPerPartition", 10000)
+	v.SetDefault("kafka.consumer.autoCommit", false)
+	v.SetDefault("kafka.consumer.commitInterval", 5*time.Second)
+	v.SetDefault("kafka.consumer.retry.maxAttempts", 3)
+	v.SetDefault("kafka.consumer.retry.initialBackoff", 100*time.Millisecond)
+	v.SetDefault("kafka.consumer.retry.maxBackoff", 10*time.Second)
+	v.SetDefault("kafka.consumer.retry.multiplier", 2.0)
+	v.SetDefault("kafka.consumer.retry.jitter", 0.2)
+	v.SetDefault("kafka.consumer.batch.maxSize", 100)
+	v.SetDefault("kafka.consumer.batch.maxLinger", 100*time.Millisecond)
+	v.SetDefault("kafka.producer.acks", "all")
+	v.SetDefault("kafka.producer.idempotent", true)
+	v.SetDefault("kafka.producer.linger", time.Duration(0))
+	v.SetDefault("kafka.producer.compression", "snappy")
+	v.SetDefault("kafka.producer.maxMessageBytes", 1000012)
+	v.SetDefault("kafka.transactions.enabled", false)
+	v.SetDefault("kafka.transactions.transactionalId", "")
+	v.SetDefault("kafka.transactions.timeout", time.Minute)
+	v.SetDefault("kafka.sasl.passwordFile", "")
+	v.SetDefault("schemaRegistry.passwordFile", "")
+	v.SetDefault("schemaRegistry.timeout", 10*time.Second)
+	v.SetDefault("secrets.encryptedFile.path", "")
+	v.SetDefault("secrets.encryptedFile.keyFile", "")
+	v.SetDefault("kafka.retryTopics.delays", []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute})
 
-	// Configure viper
-	v.SetConfigName("ktel-config")
-	v.SetConfigType("yaml")
-	v.AddConfigPath(".")
-	v.AddConfigPath("/app")
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
-
-	// Read configurations
-	if err := v.ReadInConfig(); err != nil {
-		var configFileNotFoundError viper.ConfigFileNotFoundError
-		if !errors.As(err, &configFileNotFoundError) {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
+	return v
+}
+
+// Validate checks the configuration and fills in the values derived from others, such as the
+// dead-letter topic. Configurations built in code, e.g. from Default, must be validated before use.
+func (c *Config) Validate() error {
+	if err := validate.Struct(c); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := c.resolveSecrets(c); err != nil {
+		return err
+	}
+	return c.check()
+}
+
+// check validates the fields the validator tags cannot express and fills in the derived values.
+func (c *Config) check() error {
+	if len(c.ConsumeTopics()) == 0 && len(c.Kafka.TopicPatterns) == 0 {
+		return errors.New("invalid configuration: one of kafka.topic, kafka.topics or kafka.topicPatterns is required")
+	}
+	for _, pattern := range c.Kafka.TopicPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid configuration: topic pattern %q: %w", pattern, err)
 		}
 	}
 
-	// Unmarshal configuration
-	var cfg Config
-	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	for key, value := range map[string]string{
+		"kafka.offsets.timestamp": c.Kafka.Offsets.Timestamp,
+		"kafka.replay.from":       c.Kafka.Replay.From,
+		"kafka.replay.to":         c.Kafka.Replay.To,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("invalid configuration: %s: %w", key, err)
+		}
+	}
+	if from, to := c.ReplayWindow(); !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return errors.New("invalid configuration: kafka.replay.from must be before kafka.replay.to")
+	}
+	if c.Kafka.Replay.Enabled && c.Kafka.Transactions.Enabled {
+		return errors.New("invalid configuration: kafka.replay cannot be combined with kafka.transactions")
+	}
+	if c.Kafka.Producer.Idempotent && c.Kafka.Producer.Acks != "all" {
+		return errors.New("invalid configuration: kafka.producer.idempotent requires kafka.producer.acks to be all")
 	}
+	if c.Kafka.Transactions.Enabled && !c.Kafka.Producer.Idempotent {
+		return errors.New("invalid configuration: kafka.transactions requires kafka.producer.idempotent")
+	}
+	// A retry record waits up to its tier's delay, far longer than a transaction may stay open:
+	// the broker would abort the transaction and the batch would be consumed again forever.
+	if c.Kafka.Transactions.Enabled && c.Kafka.RetryTopics.Enabled {
+		return errors.New("invalid configuration: kafka.transactions cannot be combined with kafka.retryTopics")
+	}
+	if c.Kafka.UnmatchedTopic == "dlq" && !c.DeadLettering() {
+		return errors.New("invalid configuration: kafka.unmatchedTopic dlq requires kafka.deadLetter or kafka.retryTopics to be enabled")
+	}
+
+	if c.Kafka.DeadLetter.Topic == "" {
+		if base := c.BaseTopic(); base != "" {
+			c.Kafka.DeadLetter.Topic = base + ".dlq"
+		} else {
+			c.Kafka.DeadLetter.Topic = c.Kafka.GroupID + ".dlq"
+		}
+	}
+
+	if c.Kafka.Transactions.Enabled && c.Kafka.Transactions.TransactionalID == "" {
+		// Every instance needs its own transactional id, or instances would fence each other off.
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to derive kafka.transactions.transactionalId: %w", err)
+		}
+		c.Kafka.Transactions.TransactionalID = c.Kafka.GroupID + "-" + hostname
+	}
+
+	return nil
+}
 
-	// Validate configuration
-	validate := validator.New()
-	if err := validate.Struct(&cfg); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+// BaseTopic returns the topic that derived topic names, such as the dead-letter and retry topics,
+// are based on: kafka.topic, or else the first of kafka.topics. It is empty when only topic
+// patterns are configured.
+func (c *Config) BaseTopic() string {
+	if c.Kafka.Topic != "" {
+		return c.Kafka.Topic
+	}
+	if len(c.Kafka.Topics) > 0 {
+		return c.Kafka.Topics[0]
 	}
+	return ""
+}
+
+// DeadLettering reports whether records that failed for good are published to the dead-letter
+// topic, directly or after the retry topics.
+func (c *Config) DeadLettering() bool {
+	return c.Kafka.DeadLetter.Enabled || c.Kafka.RetryTopics.Enabled
+}
 
-	return &cfg, nil
+// StartTimestamp returns kafka.offsets.timestamp, or the zero time if it is not set.
+func (c *Config) StartTimestamp() time.Time {
+	// The format was checked when the configuration was loaded.
+	t, _ := time.Parse(time.RFC3339, c.Kafka.Offsets.Timestamp)
+	return t
+}
+
+// ReplayWindow returns kafka.replay.from and kafka.replay.to, each the zero time if it is not set.
+func (c *Config) ReplayWindow() (from, to time.Time) {
+	// The format was checked when the configuration was loaded.
+	from, _ = time.Parse(time.RFC3339, c.Kafka.Replay.From)
+	to, _ = time.Parse(time.RFC3339, c.Kafka.Replay.To)
+	return from, to
+}
+
+// ConsumeTopics returns the configured topics, kafka.topic followed by kafka.topics.
+func (c *Config) ConsumeTopics() []string {
+	var topics []string
+	if c.Kafka.Topic != "" {
+		topics = append(topics, c.Kafka.Topic)
+	}
+	return append(topics, c.Kafka.Topics...)
 }
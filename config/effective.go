@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the values of secret fields in Effective.
+const redacted = "[REDACTED]"
+
+// Effective renders cfg, a Config or a struct embedding one, as YAML under the keys it is loaded
+// from, for debugging deployments. Fields tagged `secret:"true"` are redacted when set.
+func Effective(cfg any) (string, error) {
+	node, err := effectiveNode(reflect.ValueOf(cfg))
+	if err != nil {
+		return "", fmt.Errorf("failed to render configuration: %w", err)
+	}
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return "", fmt.Errorf("failed to render configuration: %w", err)
+	}
+	return out.String(), nil
+}
+
+func effectiveNode(v reflect.Value) (*yaml.Node, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == reflect.TypeFor[time.Duration]():
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(v.Int()).String()}, nil
+	case v.Kind() == reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		if err := appendFields(node, v); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := range v.Len() {
+			item, err := effectiveNode(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node, nil
+	case v.Kind() == reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+		})
+		for _, key := range keys {
+			value, err := effectiveNode(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(key.Interface())}, value)
+		}
+		return node, nil
+	default:
+		var node yaml.Node
+		if err := node.Encode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return &node, nil
+	}
+}
+
+// appendFields appends the exported fields of the struct v to the mapping node, under their
+// mapstructure names. Squashed fields are inlined.
+func appendFields(node *yaml.Node, v reflect.Value) error {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "squash") && field.Type.Kind() == reflect.Struct {
+			if err := appendFields(node, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		var value *yaml.Node
+		if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: redacted}
+		} else {
+			var err error
+			if value, err = effectiveNode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+	return nil
+}
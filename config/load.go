@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+)
+
+const (
+	// EnvConfig names the environment variable holding the path of the configuration file, which
+	// replaces the search for ktel-config in the working directory and /app.
+	EnvConfig = "KTEL_CONFIG"
+	// EnvProfile names the environment variable selecting a profile. The profile's file, e.g.
+	// ktel-config.prod.yaml for the profile prod, is layered over the base file.
+	EnvProfile = "KTEL_PROFILE"
+)
+
+// configName is the name of the configuration file without its extension.
+const configName = "ktel-config"
+
+var (
+	// configPaths are the directories searched for the configuration file, in order.
+	configPaths = []string{".", "/app"}
+	// configExts are the supported configuration file formats, in order of precedence.
+	configExts = []string{"yaml", "yml", "toml", "json"}
+)
+
+// validate is shared by all loads so that struct metadata is parsed once.
+var validate = validator.New()
+
+// Embedder is satisfied by pointers to structs that embed Config, through the promoted Base
+// method. It lets Load find the ktel configuration within an application configuration.
+type Embedder[T any] interface {
+	*T
+	Base() *Config
+}
+
+// Base returns c. Structs embedding Config inherit it, which makes their pointers an Embedder.
+func (c *Config) Base() *Config {
+	return c
+}
+
+// Load loads an application configuration of type T, which embeds Config with the
+// `mapstructure:",squash"` tag next to its own sections, for example:
+//
+//	type Settings struct {
+//		config.Config `mapstructure:",squash"`
+//		Downstream    struct {
+//			URL string `mapstructure:"url" validate:"required,url"`
+//		} `mapstructure:"downstream"`
+//	}
+//
+//	settings, err := config.Load[Settings]()
+//
+// The whole struct is read like New reads Config, and checked with its validate tags before the
+// embedded Config is validated.
+func Load[T any, PT Embedder[T]]() (*T, error) {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return LoadFile[T, PT](path)
+	}
+
+	v := newViper()
+	if path := findConfig(configName, configPaths...); path != "" {
+		if err := readConfig(v, path, false); err != nil {
+			return nil, err
+		}
+	}
+	if profile := os.Getenv(EnvProfile); profile != "" {
+		path := findConfig(configName+"."+profile, configPaths...)
+		if path == "" {
+			return nil, fmt.Errorf("failed to read config file: no %s.%s file for profile %q", configName, profile, profile)
+		}
+		if err := readConfig(v, path, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return load[T, PT](v)
+}
+
+// LoadFile loads an application configuration of type T like Load, from the file at path and
+// its profile overlay next to it, e.g. service.prod.yaml for service.yaml. Unlike Load, the
+// file must exist.
+func LoadFile[T any, PT Embedder[T]](path string) (*T, error) {
+	v := newViper()
+	if err := readConfig(v, path, false); err != nil {
+		return nil, err
+	}
+	if profile := os.Getenv(EnvProfile); profile != "" {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "." + profile
+		overlay := findConfig(name, filepath.Dir(path))
+		if overlay == "" {
+			return nil, fmt.Errorf("failed to read config file: no %s file for profile %q", name, profile)
+		}
+		if err := readConfig(v, overlay, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return load[T, PT](v)
+}
+
+// findConfig returns the first file named name with a supported extension in dirs, or "" if
+// there is none.
+func findConfig(name string, dirs ...string) string {
+	for _, dir := range dirs {
+		for _, ext := range configExts {
+			path := filepath.Join(dir, name+"."+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// readConfig reads the file at path into v, in the format given by its extension, after
+// interpolating environment variables. With merge, its values are layered over the ones read
+// before instead of replacing them.
+func readConfig(v *viper.Viper, path string, merge bool) error {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if !slices.Contains(configExts, ext) {
+		return fmt.Errorf("failed to read config file %s: unsupported format %q", path, ext)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	v.SetConfigType(ext)
+	read := v.ReadConfig
+	if merge {
+		read = v.MergeConfig
+	}
+	if err := read(bytes.NewReader(expandEnv(data))); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// envReference matches ${NAME} and ${NAME:-default}.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} in data with the value of the environment variable NAME, or the
+// empty string if it is unset, and ${NAME:-default} with default if NAME is unset or empty.
+// Other uses of $, e.g. in topic patterns, are left alone.
+func expandEnv(data []byte) []byte {
+	return envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := envReference.FindSubmatch(ref)
+		if value := os.Getenv(string(match[1])); value != "" {
+			return []byte(value)
+		}
+		return match[2]
+	})
+}
+
+// load unmarshals and validates the configuration read by v, overridden by environment variables.
+func load[T any, PT Embedder[T]](v *viper.Viper) (*T, error) {
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	// Keys without a default are only read from the environment once bound.
+	bindEnv(v, reflect.TypeFor[T](), "")
+
+	cfg := new(T)
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if err := validate.Struct(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := PT(cfg).Base().resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+	if err := PT(cfg).Base().check(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// bindEnv binds an environment variable to the key of every field of the struct type t, as
+// named by its mapstructure tag, below prefix.
+func bindEnv(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		key := prefix
+		if !strings.Contains(opts, "squash") {
+			if name == "" {
+				name = field.Name
+			}
+			key = prefix + name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			if key != prefix {
+				key += "."
+			}
+			bindEnv(v, field.Type, key)
+			continue
+		}
+		// Binding can only fail without a key.
+		_ = v.BindEnv(key)
+	}
+}
+
+type settingsKey struct{}
+
+// ContextWithSettings returns a copy of ctx carrying the application configuration settings.
+func ContextWithSettings(ctx context.Context, settings any) context.Context {
+	return context.WithValue(ctx, settingsKey{}, settings)
+}
+
+// ContextWithSettingsOf returns a copy of ctx carrying the application configuration settings
+// carried by from, if any.
+func ContextWithSettingsOf(ctx, from context.Context) context.Context {
+	if settings := from.Value(settingsKey{}); settings != nil {
+		return ContextWithSettings(ctx, settings)
+	}
+	return ctx
+}
+
+// SettingsFromContext returns the application configuration of type T carried by ctx, if any.
+// Applications created with ktel.NewWithConfig put it into the context of every record.
+func SettingsFromContext[T any](ctx context.Context) (*T, bool) {
+	settings, ok := ctx.Value(settingsKey{}).(*T)
+	return settings, ok
+}
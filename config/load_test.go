@@ -0,0 +1,237 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const baseConfig = `
+appName: billing
+kafka:
+  brokers: base:9092
+  topic: orders
+  groupId: billing-group
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		env         map[string]string
+		wantApp     string
+		wantBrokers string
+		wantTopic   string
+		wantGroup   string
+		wantErr     string
+	}{
+		{
+			name:        "working directory",
+			files:       map[string]string{"ktel-config.yaml": baseConfig},
+			wantApp:     "billing",
+			wantBrokers: "base:9092",
+			wantTopic:   "orders",
+			wantGroup:   "billing-group",
+		},
+		{
+			name: "yaml before json",
+			files: map[string]string{
+				"ktel-config.yaml": baseConfig,
+				"ktel-config.json": `{"appName": "json", "kafka": {"brokers": "json:9092", "topic": "json"}}`,
+			},
+			wantApp:     "billing",
+			wantBrokers: "base:9092",
+			wantTopic:   "orders",
+			wantGroup:   "billing-group",
+		},
+		{
+			name: "profile merged over the base file",
+			files: map[string]string{
+				"ktel-config.yaml":      baseConfig,
+				"ktel-config.prod.yaml": "kafka:\n  brokers: prod:9092\n",
+			},
+			env:         map[string]string{EnvProfile: "prod"},
+			wantApp:     "billing",
+			wantBrokers: "prod:9092",
+			wantTopic:   "orders",
+			wantGroup:   "billing-group",
+		},
+		{
+			name: "profile in another format",
+			files: map[string]string{
+				"ktel-config.yaml":      baseConfig,
+				"ktel-config.prod.toml": "[kafka]\ntopic = \"payments\"\n",
+			},
+			env:         map[string]string{EnvProfile: "prod"},
+			wantApp:     "billing",
+			wantBrokers: "base:9092",
+			wantTopic:   "payments",
+			wantGroup:   "billing-group",
+		},
+		{
+			name:    "missing profile file",
+			files:   map[string]string{"ktel-config.yaml": baseConfig},
+			env:     map[string]string{EnvProfile: "staging"},
+			wantErr: `no ktel-config.staging file for profile "staging"`,
+		},
+		{
+			name: "config file from the environment",
+			files: map[string]string{
+				"ktel-config.yaml":       baseConfig,
+				"conf/service.json":      `{"appName": "service", "kafka": {"brokers": "service:9092", "topic": "events"}}`,
+				"conf/service.prod.yaml": "kafka:\n  groupId: service-prod\n",
+				"ktel-config.prod.yaml":  "kafka:\n  groupId: ignored\n",
+			},
+			env:         map[string]string{EnvConfig: "conf/service.json", EnvProfile: "prod"},
+			wantApp:     "service",
+			wantBrokers: "service:9092",
+			wantTopic:   "events",
+			wantGroup:   "service-prod",
+		},
+		{
+			name:    "missing config file from the environment",
+			files:   map[string]string{"ktel-config.yaml": baseConfig},
+			env:     map[string]string{EnvConfig: "missing.yaml"},
+			wantErr: "failed to read config file",
+		},
+		{
+			name:    "unsupported format",
+			files:   map[string]string{"service.ini": "appName = billing\n"},
+			env:     map[string]string{EnvConfig: "service.ini"},
+			wantErr: `unsupported format "ini"`,
+		},
+		{
+			name: "environment references",
+			files: map[string]string{"ktel-config.yaml": `
+appName: ${KTEL_TEST_APP}
+kafka:
+  brokers: ${KTEL_TEST_BROKERS:-localhost:9092}
+  topic: ${KTEL_TEST_TOPIC:-orders}
+  groupId: ${KTEL_TEST_UNSET}group
+`},
+			env:         map[string]string{"KTEL_TEST_APP": "billing", "KTEL_TEST_TOPIC": "payments"},
+			wantApp:     "billing",
+			wantBrokers: "localhost:9092",
+			wantTopic:   "payments",
+			wantGroup:   "group",
+		},
+		{
+			name: "environment variables override the files",
+			files: map[string]string{
+				"ktel-config.yaml":      baseConfig,
+				"ktel-config.prod.yaml": "kafka:\n  brokers: prod:9092\n  topic: prod\n",
+			},
+			env:         map[string]string{EnvProfile: "prod", "KAFKA_BROKERS": "env:9092", "KAFKA_GROUPID": "env-group"},
+			wantApp:     "billing",
+			wantBrokers: "env:9092",
+			wantTopic:   "prod",
+			wantGroup:   "env-group",
+		},
+		{
+			name:        "environment variables without a file",
+			env:         map[string]string{"KAFKA_BROKERS": "env:9092", "KAFKA_TOPIC": "orders"},
+			wantApp:     "kafka-consumer",
+			wantBrokers: "env:9092",
+			wantTopic:   "orders",
+			wantGroup:   "kafka-consumer-group",
+		},
+		{
+			name:    "invalid configuration",
+			files:   map[string]string{"ktel-config.yaml": "kafka:\n  topic: orders\n"},
+			wantErr: "invalid configuration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, key := range []string{EnvConfig, EnvProfile} {
+				t.Setenv(key, "")
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load[Config]()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.AppName != tt.wantApp || cfg.Kafka.Brokers != tt.wantBrokers || cfg.Kafka.Topic != tt.wantTopic || cfg.Kafka.GroupID != tt.wantGroup {
+				t.Errorf("Load() = %q, %q, %q, %q, want %q, %q, %q, %q",
+					cfg.AppName, cfg.Kafka.Brokers, cfg.Kafka.Topic, cfg.Kafka.GroupID,
+					tt.wantApp, tt.wantBrokers, tt.wantTopic, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.yaml")
+	if err := os.WriteFile(path, []byte(baseConfig+"downstream:\n  token: from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvProfile, "")
+
+	settings, err := LoadFile[appSettings](path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if settings.Downstream.Token != "from-file" || settings.Kafka.Topic != "orders" {
+		t.Errorf("LoadFile() = %q, %q, want the application and ktel sections", settings.Downstream.Token, settings.Kafka.Topic)
+	}
+
+	// Keys of the application's own sections are bound to the environment too.
+	t.Setenv("DOWNSTREAM_TOKEN", "from-env")
+	if settings, err = LoadFile[appSettings](path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if settings.Downstream.Token != "from-env" {
+		t.Errorf("LoadFile() token = %q, want the environment variable", settings.Downstream.Token)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("KTEL_TEST_HOST", "kafka")
+	t.Setenv("KTEL_TEST_EMPTY", "")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "set", in: "brokers: ${KTEL_TEST_HOST}:9092", want: "brokers: kafka:9092"},
+		{name: "unset", in: "brokers: ${KTEL_TEST_UNSET}", want: "brokers: "},
+		{name: "default unused", in: "${KTEL_TEST_HOST:-localhost}", want: "kafka"},
+		{name: "default for unset", in: "${KTEL_TEST_UNSET:-localhost}", want: "localhost"},
+		{name: "default for empty", in: "${KTEL_TEST_EMPTY:-localhost}", want: "localhost"},
+		{name: "empty default", in: "${KTEL_TEST_UNSET:-}", want: ""},
+		{name: "several", in: "${KTEL_TEST_HOST}/${KTEL_TEST_UNSET:-x}", want: "kafka/x"},
+		{name: "topic pattern", in: `topicPatterns: ["^orders\\..*$"]`, want: `topicPatterns: ["^orders\\..*$"]`},
+		{name: "bare dollar name", in: "$KTEL_TEST_HOST", want: "$KTEL_TEST_HOST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(expandEnv([]byte(tt.in))); got != tt.want {
+				t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Jdemon/ktel/secret"
+)
+
+// resolveSecrets replaces the secret references in the fields of cfg tagged `secret:"true"`,
+// which is c or a struct embedding it, with their values. The references are kept so that
+// ResolveSecret can resolve them again. Password files are turned into file references first.
+func (c *Config) resolveSecrets(cfg any) error {
+	if c.Kafka.SASL.PasswordFile != "" {
+		c.Kafka.SASL.Password = secret.Prefix + "file:" + c.Kafka.SASL.PasswordFile
+	}
+	if c.SchemaRegistry.PasswordFile != "" {
+		c.SchemaRegistry.Password = secret.Prefix + "file:" + c.SchemaRegistry.PasswordFile
+	}
+	if c.secretRefs == nil {
+		c.secretRefs = make(map[string]string)
+	}
+	return c.resolveFields(context.Background(), reflect.ValueOf(cfg).Elem(), "")
+}
+
+func (c *Config) resolveFields(ctx context.Context, v reflect.Value, prefix string) error {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		key := prefix
+		if !strings.Contains(opts, "squash") {
+			if name == "" {
+				name = field.Name
+			}
+			key = prefix + name
+		}
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if key != prefix {
+				key += "."
+			}
+			if err := c.resolveFields(ctx, v.Field(i), key); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.String && field.Tag.Get("secret") == "true":
+			ref := v.Field(i).String()
+			if _, _, ok := secret.Parse(ref); !ok {
+				continue
+			}
+			value, err := c.resolve(ctx, ref)
+			if err != nil {
+				return fmt.Errorf("invalid configuration: %s: %w", key, err)
+			}
+			c.secretRefs[key] = ref
+			v.Field(i).SetString(value)
+		}
+	}
+	return nil
+}
+
+// ResolveSecret resolves the secret reference configured under key, e.g. kafka.sasl.password,
+// again, picking up a rotated secret. ok is false if key was not configured as a reference, in
+// which case the loaded value is current.
+func (c *Config) ResolveSecret(ctx context.Context, key string) (value string, ok bool, err error) {
+	ref, ok := c.secretRefs[key]
+	if !ok {
+		return "", false, nil
+	}
+	value, err = c.resolve(ctx, ref)
+	return value, true, err
+}
+
+// resolve resolves ref, with the encrypted scheme served by secrets.encryptedFile when set.
+func (c *Config) resolve(ctx context.Context, ref string) (string, error) {
+	scheme, name, _ := secret.Parse(ref)
+	if scheme == "encrypted" && c.Secrets.EncryptedFile.Path != "" {
+		provider := secret.EncryptedFile{Path: c.Secrets.EncryptedFile.Path, KeyFile: c.Secrets.EncryptedFile.KeyFile}
+		return secret.ResolveWith(ctx, provider, scheme, name)
+	}
+	return secret.Resolve(ctx, ref)
+}
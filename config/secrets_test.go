@@ -0,0 +1,183 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Jdemon/ktel/secret"
+)
+
+// appSettings is an application configuration with a secret of its own.
+type appSettings struct {
+	Config     `mapstructure:",squash"`
+	Downstream struct {
+		Token string `mapstructure:"token" secret:"true"`
+	} `mapstructure:"downstream"`
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("KTEL_TEST_SASL_PASSWORD", "from-env")
+	t.Setenv("KTEL_TEST_TOKEN", "token")
+	passwordFile := filepath.Join(t.TempDir(), "registry-password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(s *appSettings)
+		wantSASL string
+		wantReg  string
+		wantTok  string
+		wantRefs map[string]string
+		wantErr  string
+	}{
+		{
+			name: "plain values",
+			setup: func(s *appSettings) {
+				s.Kafka.SASL.Password = "hunter2"
+			},
+			wantSASL: "hunter2",
+			wantRefs: map[string]string{},
+		},
+		{
+			name: "references",
+			setup: func(s *appSettings) {
+				s.Kafka.SASL.Password = "secret:env:KTEL_TEST_SASL_PASSWORD"
+				s.Downstream.Token = "secret:env:KTEL_TEST_TOKEN"
+			},
+			wantSASL: "from-env",
+			wantTok:  "token",
+			wantRefs: map[string]string{
+				"kafka.sasl.password": "secret:env:KTEL_TEST_SASL_PASSWORD",
+				"downstream.token":    "secret:env:KTEL_TEST_TOKEN",
+			},
+		},
+		{
+			name: "password file",
+			setup: func(s *appSettings) {
+				s.SchemaRegistry.Password = "ignored"
+				s.SchemaRegistry.PasswordFile = passwordFile
+			},
+			wantReg:  "from-file",
+			wantRefs: map[string]string{"schemaRegistry.password": "secret:file:" + passwordFile},
+		},
+		{
+			name: "unresolvable reference",
+			setup: func(s *appSettings) {
+				s.Kafka.SASL.Password = "secret:env:KTEL_TEST_UNSET"
+			},
+			wantErr: "kafka.sasl.password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &appSettings{}
+			tt.setup(s)
+
+			err := s.resolveSecrets(s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSecrets() error = %v, want it to name %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSecrets() error = %v", err)
+			}
+			if s.Kafka.SASL.Password != tt.wantSASL || s.SchemaRegistry.Password != tt.wantReg || s.Downstream.Token != tt.wantTok {
+				t.Errorf("resolved %q, %q, %q, want %q, %q, %q",
+					s.Kafka.SASL.Password, s.SchemaRegistry.Password, s.Downstream.Token, tt.wantSASL, tt.wantReg, tt.wantTok)
+			}
+			if !reflect.DeepEqual(s.secretRefs, tt.wantRefs) {
+				t.Errorf("secret references = %v, want %v", s.secretRefs, tt.wantRefs)
+			}
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("KTEL_TEST_SASL_PASSWORD", "before")
+	s := &appSettings{}
+	s.Kafka.SASL.Password = "secret:env:KTEL_TEST_SASL_PASSWORD"
+	s.SchemaRegistry.Password = "plain"
+	if err := s.resolveSecrets(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret is rotated after the load.
+	t.Setenv("KTEL_TEST_SASL_PASSWORD", "after")
+	if value, ok, err := s.ResolveSecret(context.Background(), "kafka.sasl.password"); value != "after" || !ok || err != nil {
+		t.Errorf("ResolveSecret() = %q, %v, %v, want the rotated secret", value, ok, err)
+	}
+	if value, ok, err := s.ResolveSecret(context.Background(), "schemaRegistry.password"); value != "" || ok || err != nil {
+		t.Errorf("ResolveSecret() of a plain value = %q, %v, %v, want not ok", value, ok, err)
+	}
+
+	os.Unsetenv("KTEL_TEST_SASL_PASSWORD")
+	if _, ok, err := s.ResolveSecret(context.Background(), "kafka.sasl.password"); !ok || err == nil {
+		t.Errorf("ResolveSecret() of a removed secret = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestResolveSecretsEncryptedFile(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := secret.Seal(map[string]string{"kafka-password": "hunter2"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &appSettings{}
+	s.Secrets.EncryptedFile.Path = filepath.Join(dir, "secrets.enc")
+	s.Secrets.EncryptedFile.KeyFile = filepath.Join(dir, "secrets.key")
+	if err := os.WriteFile(s.Secrets.EncryptedFile.Path, sealed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.Secrets.EncryptedFile.KeyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.Kafka.SASL.Password = "secret:encrypted:kafka-password"
+
+	if err := s.resolveSecrets(s); err != nil {
+		t.Fatalf("resolveSecrets() error = %v", err)
+	}
+	if s.Kafka.SASL.Password != "hunter2" {
+		t.Errorf("resolved %q, want the encrypted secret", s.Kafka.SASL.Password)
+	}
+}
+
+func TestEffectiveRedactsSecrets(t *testing.T) {
+	t.Setenv("KTEL_TEST_SASL_PASSWORD", "from-env")
+	s := &appSettings{}
+	s.AppName = "billing"
+	s.Kafka.SASL.Password = "secret:env:KTEL_TEST_SASL_PASSWORD"
+	s.Downstream.Token = "token"
+	if err := s.resolveSecrets(s); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Effective(s)
+	if err != nil {
+		t.Fatalf("Effective() error = %v", err)
+	}
+	for _, leaked := range []string{"from-env", "secret:env", "token: token"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("Effective() output contains %q:\n%s", leaked, out)
+		}
+	}
+	for _, want := range []string{"appName: billing", "password: '" + redacted + "'", "token: '" + redacted + "'"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Effective() output lacks %q:\n%s", want, out)
+		}
+	}
+	// Unset secrets are shown as empty rather than redacted.
+	if !strings.Contains(out, "schemaRegistry:\n  url: \"\"\n  username: \"\"\n  password: \"\"") {
+		t.Errorf("Effective() output redacts the unset Schema Registry password:\n%s", out)
+	}
+}
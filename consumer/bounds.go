@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// progressInterval is how often a bounded consumer logs its progress.
+const progressInterval = 10 * time.Second
+
+// OffsetRange is the range of offsets [Start, End) of a partition processed by a bounded consumer.
+type OffsetRange struct {
+	Start int64
+	End   int64
+}
+
+// WithBounds makes the consumer process only the records within ranges, keyed by topic and
+// partition, and return from Run once every range was consumed and its records completed. The
+// client must consume exactly these partitions, starting at the start of each range, and return
+// control records, so that the consumer sees the last offset of every range. A nil map leaves the
+// consumer unbounded.
+func WithBounds(ranges map[string]map[int32]OffsetRange) Option {
+	return func(c *Consumer) {
+		if ranges != nil {
+			c.bounds = newBounds(ranges)
+		}
+	}
+}
+
+// bounds tracks how far a bounded consumer got through its ranges.
+type bounds struct {
+	mu        sync.Mutex
+	ranges    map[string]map[int32]OffsetRange
+	next      map[string]map[int32]int64 // next offset to consume per partition
+	remaining int                        // partitions not consumed to the end of their range
+	total     int64
+	consumed  int64
+}
+
+func newBounds(ranges map[string]map[int32]OffsetRange) *bounds {
+	b := &bounds{
+		ranges: ranges,
+		next:   make(map[string]map[int32]int64),
+	}
+	for topic, topicRanges := range ranges {
+		b.next[topic] = make(map[int32]int64)
+		for partition, r := range topicRanges {
+			b.next[topic][partition] = r.Start
+			if r.Start < r.End {
+				b.remaining++
+				b.total += r.End - r.Start
+			}
+		}
+	}
+	return b
+}
+
+// advance records that rec was consumed. It reports whether rec lies within its range, and whether
+// its partition has just been consumed to the end of its range along with the offsets that remain
+// in that partition.
+func (b *bounds) advance(rec *kgo.Record) (inRange, finished bool, remaining int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, ok := b.ranges[rec.Topic][rec.Partition]
+	next := b.next[rec.Topic][rec.Partition]
+	if !ok || next >= r.End || rec.Offset < next {
+		return false, false, 0
+	}
+
+	consumed := min(rec.Offset+1, r.End)
+	b.consumed += consumed - next
+	b.next[rec.Topic][rec.Partition] = consumed
+	if consumed == r.End {
+		b.remaining--
+		finished = true
+	}
+	return rec.Offset < r.End, finished, r.End - consumed
+}
+
+// done reports whether every range was consumed.
+func (b *bounds) done() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining == 0
+}
+
+// progress returns the number of offsets consumed and the total number of offsets in the ranges.
+func (b *bounds) progress() (consumed, total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.consumed, b.total
+}
+
+// admitBounded advances the bounds with rec and reports whether it should be processed. Control
+// records and records outside their range are not. Partitions are paused once their range was
+// consumed.
+func (c *Consumer) admitBounded(ctx context.Context, rec *kgo.Record) bool {
+	inRange, finished, remaining := c.bounds.advance(rec)
+	if inRange && c.instrumentor != nil {
+		c.instrumentor.InstrumentReplayProgress(context.WithoutCancel(ctx), rec.Topic, rec.Partition, remaining)
+	}
+	if finished {
+		c.client.PauseFetchPartitions(map[string][]int32{rec.Topic: {rec.Partition}})
+		consumed, total := c.bounds.progress()
+		c.logger.Infow("Partition range consumed", "topic", rec.Topic, "partition", rec.Partition, "consumed", consumed, "total", total)
+	}
+	return inRange && !rec.Attrs.IsControl()
+}
+
+// startProgressLog periodically logs how far the bounded consumer got, until the returned function
+// is called.
+func (c *Consumer) startProgressLog() func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				consumed, total := c.bounds.progress()
+				c.logger.Infow("Replay progress", "consumed", consumed, "total", total, "percent", 100*float64(consumed)/float64(max(total, 1)))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
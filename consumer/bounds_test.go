@@ -0,0 +1,125 @@
+package consumer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// boundsStep is the outcome expected from advancing bounds with one record.
+type boundsStep struct {
+	partition int32
+	offset    int64
+	inRange   bool
+	finished  bool
+	remaining int64
+}
+
+func TestBounds(t *testing.T) {
+	const topic = "orders"
+
+	tests := []struct {
+		name     string
+		ranges   map[int32]OffsetRange
+		steps    []boundsStep
+		done     bool
+		consumed int64
+		total    int64
+	}{
+		{
+			name:     "empty range is done from the start",
+			ranges:   map[int32]OffsetRange{0: {Start: 5, End: 5}},
+			done:     true,
+			consumed: 0,
+			total:    0,
+		},
+		{
+			name:   "consumed in order",
+			ranges: map[int32]OffsetRange{0: {Start: 10, End: 13}},
+			steps: []boundsStep{
+				{0, 10, true, false, 2},
+				{0, 11, true, false, 1},
+				{0, 12, true, true, 0},
+			},
+			done:     true,
+			consumed: 3,
+			total:    3,
+		},
+		{
+			name:   "gaps count as consumed",
+			ranges: map[int32]OffsetRange{0: {Start: 0, End: 10}},
+			steps: []boundsStep{
+				{0, 3, true, false, 6},
+				{0, 9, true, true, 0},
+			},
+			done:     true,
+			consumed: 10,
+			total:    10,
+		},
+		{
+			name:   "record past the end finishes the range",
+			ranges: map[int32]OffsetRange{0: {Start: 0, End: 2}},
+			steps: []boundsStep{
+				{0, 0, true, false, 1},
+				{0, 4, false, true, 0},
+				{0, 5, false, false, 0},
+			},
+			done:     true,
+			consumed: 2,
+			total:    2,
+		},
+		{
+			name:   "records before the next offset are ignored",
+			ranges: map[int32]OffsetRange{0: {Start: 0, End: 5}},
+			steps: []boundsStep{
+				{0, 2, true, false, 2},
+				{0, 1, false, false, 0},
+			},
+			consumed: 3,
+			total:    5,
+		},
+		{
+			name:   "partition outside the ranges",
+			ranges: map[int32]OffsetRange{0: {Start: 0, End: 1}},
+			steps: []boundsStep{
+				{1, 0, false, false, 0},
+			},
+			total: 1,
+		},
+		{
+			name: "done once every partition finished",
+			ranges: map[int32]OffsetRange{
+				0: {Start: 0, End: 1},
+				1: {Start: 0, End: 2},
+			},
+			steps: []boundsStep{
+				{0, 0, true, true, 0},
+				{1, 0, true, false, 1},
+			},
+			consumed: 2,
+			total:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBounds(map[string]map[int32]OffsetRange{topic: tt.ranges})
+			for _, step := range tt.steps {
+				inRange, finished, remaining := b.advance(&kgo.Record{Topic: topic, Partition: step.partition, Offset: step.offset})
+				got := boundsStep{step.partition, step.offset, inRange, finished, remaining}
+				if !reflect.DeepEqual(got, step) {
+					t.Errorf("advance(%d, %d) = %v, %v, %d, want %v, %v, %d", step.partition, step.offset,
+						inRange, finished, remaining, step.inRange, step.finished, step.remaining)
+				}
+			}
+
+			if got := b.done(); got != tt.done {
+				t.Errorf("done() = %v, want %v", got, tt.done)
+			}
+			if consumed, total := b.progress(); consumed != tt.consumed || total != tt.total {
+				t.Errorf("progress() = %d, %d, want %d, %d", consumed, total, tt.consumed, tt.total)
+			}
+		})
+	}
+}
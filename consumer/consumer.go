@@ -2,16 +2,28 @@ package consumer
 
 import (
 	"context"
+	"errors"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Jdemon/ktel/processor"
+	"github.com/Jdemon/ktel/retry"
+	"github.com/Jdemon/ktel/retrytopic"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
 	"go.uber.org/zap"
 )
 
 // KafkaClient defines the interface for the Kafka client operations we need.
 type KafkaClient interface {
+	Committer
 	PollFetches(context.Context) Fetches
+	PauseFetchPartitions(map[string][]int32) map[string][]int32
+	ResumeFetchPartitions(map[string][]int32)
 	Close()
 }
 
@@ -30,50 +42,594 @@ func (a *KgoClientAdapter) PollFetches(ctx context.Context) Fetches {
 	return a.Client.PollFetches(ctx)
 }
 
+func (a *KgoClientAdapter) PauseFetchPartitions(partitions map[string][]int32) map[string][]int32 {
+	return a.Client.PauseFetchPartitions(partitions)
+}
+
+func (a *KgoClientAdapter) ResumeFetchPartitions(partitions map[string][]int32) {
+	a.Client.ResumeFetchPartitions(partitions)
+}
+
+// CommitOffsets synchronously commits offsets and returns the first request or partition error.
+func (a *KgoClientAdapter) CommitOffsets(ctx context.Context, offsets map[string]map[int32]kgo.EpochOffset) error {
+	var commitErr error
+	a.Client.CommitOffsetsSync(ctx, offsets, func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+		if err != nil {
+			commitErr = err
+			return
+		}
+		for _, topic := range resp.Topics {
+			for _, partition := range topic.Partitions {
+				if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+					commitErr = err
+					return
+				}
+			}
+		}
+	})
+	return commitErr
+}
+
 func (a *KgoClientAdapter) Close() {
 	a.Client.Close()
 }
 
+// FailureHandler takes over a record whose processing failed after all retries, for example
+// by publishing it to a dead-letter topic. If it returns nil, the record counts as handled and
+// its offset may be committed.
+type FailureHandler interface {
+	HandleFailure(ctx context.Context, rec *kgo.Record, err error, attempts int) error
+}
+
+// Mode controls how fetched records are scheduled for processing.
+type Mode string
+
+const (
+	// ModeConcurrent processes every record in its own goroutine, without ordering guarantees.
+	ModeConcurrent Mode = "concurrent"
+	// ModePartition processes the records of a partition sequentially in offset order,
+	// while different partitions are processed in parallel.
+	ModePartition Mode = "partition"
+	// ModeKey hashes record keys onto a fixed number of lanes. Records with the same key are
+	// processed sequentially, while different keys of the same partition run concurrently.
+	ModeKey Mode = "key"
+)
+
+const (
+	// defaultLanes is the number of lanes used by ModeKey when none is configured.
+	defaultLanes = 16
+	// defaultMaxPendingPerPartition is how many offsets of a partition may wait to be committed
+	// behind an unfinished record before the partition is paused, when none is configured.
+	defaultMaxPendingPerPartition = 10000
+	// defaultCommitInterval is how often tracked offsets are committed when none is configured.
+	defaultCommitInterval = 5 * time.Second
+	// finalCommitTimeout bounds the synchronous commit made when the consumer stops.
+	finalCommitTimeout = 10 * time.Second
+)
+
+// Option configures a Consumer.
+type Option func(*Consumer)
+
+// WithMode sets the processing mode. The default is ModeConcurrent.
+func WithMode(mode Mode) Option {
+	return func(c *Consumer) {
+		c.mode = mode
+	}
+}
+
+// WithLanes sets the number of lanes used by ModeKey.
+func WithLanes(lanes int) Option {
+	return func(c *Consumer) {
+		if lanes > 0 {
+			c.lanes = lanes
+		}
+	}
+}
+
+// WithMaxConcurrency bounds the number of records processed at once. Zero means unbounded.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Consumer) {
+		c.maxConcurrency = n
+	}
+}
+
+// WithMaxInFlightBytes bounds the total size of the records processed at once. Zero means unbounded.
+func WithMaxInFlightBytes(n int64) Option {
+	return func(c *Consumer) {
+		c.maxInFlightBytes = n
+	}
+}
+
+// WithMaxInFlightPerPartition pauses fetching from a partition while this many of its
+// records are in flight. Zero disables per-partition pausing.
+func WithMaxInFlightPerPartition(n int) Option {
+	return func(c *Consumer) {
+		c.maxInFlightPerPartition = n
+	}
+}
+
+// WithMaxPendingPerPartition pauses fetching from a partition while this many of its tracked
+// offsets wait to be committed behind an unfinished record. Zero disables the bound.
+func WithMaxPendingPerPartition(n int) Option {
+	return func(c *Consumer) {
+		c.maxPendingPerPartition = n
+	}
+}
+
+// WithOffsetTracker switches the consumer to manual commits. Records are tracked from poll to
+// completion, only successfully processed records are marked, and the contiguous watermark is
+// committed periodically and once more, synchronously, when the consumer stops. The Kafka client
+// must have autocommit disabled.
+func WithOffsetTracker(tracker *OffsetTracker) Option {
+	return func(c *Consumer) {
+		c.offsets = tracker
+	}
+}
+
+// WithCommitInterval sets how often tracked offsets are committed.
+func WithCommitInterval(interval time.Duration) Option {
+	return func(c *Consumer) {
+		if interval > 0 {
+			c.commitInterval = interval
+		}
+	}
+}
+
+// WithRetryPolicy retries records whose processing failed according to policy.
+// By default every record is attempted once.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(c *Consumer) {
+		c.retry = policy
+	}
+}
+
+// WithFailureHandler hands records that exhausted their retries to handler.
+// Without one, or if the handler fails, such records are logged and, with an offset tracker,
+// their partition is stopped: it is paused and its offsets are never committed past the failed
+// record, so the record is consumed again once the partition is revoked or the consumer restarts.
+func WithFailureHandler(handler FailureHandler) Option {
+	return func(c *Consumer) {
+		c.failureHandler = handler
+	}
+}
+
+// WithProducer puts producer into the context of every processed record, where processors
+// retrieve it with processor.ProducerFromContext.
+func WithProducer(producer processor.Producer) Option {
+	return func(c *Consumer) {
+		c.producer = producer
+	}
+}
+
+// WithTransactions switches the consumer to exactly-once processing. Every polled batch is
+// processed inside a transaction that is committed, together with the consumed offsets, only if
+// all of its records were handled; otherwise it is aborted and the batch is consumed again.
+// Offsets are committed by the transaction, so WithOffsetTracker must not be used as well.
+// Records of retry topics are not deferred until due, since a transaction cannot stay open that
+// long, so retry topics must not be consumed with transactions.
+func WithTransactions(transactor Transactor) Option {
+	return func(c *Consumer) {
+		c.transactor = transactor
+	}
+}
+
+// WithInstrumentor enables consumer metrics, such as per-lane throughput in ModeKey.
+func WithInstrumentor(instrumentor *telemetry.Instrumentor) Option {
+	return func(c *Consumer) {
+		c.instrumentor = instrumentor
+	}
+}
+
 // Consumer handles the message processing logic.
 type Consumer struct {
-	client    KafkaClient
-	processor processor.Processor
-	logger    *zap.SugaredLogger
+	client       KafkaClient
+	processor    processor.Processor
+	logger       *zap.SugaredLogger
+	mode         Mode
+	lanes        int
+	instrumentor *telemetry.Instrumentor
+
+	maxConcurrency          int
+	maxInFlightBytes        int64
+	maxInFlightPerPartition int
+	maxPendingPerPartition  int
+
+	offsets        *OffsetTracker
+	commitInterval time.Duration
+
+	retry          retry.Policy
+	failureHandler FailureHandler
+
+	producer   processor.Producer
+	transactor Transactor
+
+	bounds *bounds
+
+	exec     executor
+	throttle *partitionThrottle
+	err      atomic.Pointer[error]
+
+	// deferred holds, per partition, the records from a retry record that is not due yet on.
+	deferredMu sync.Mutex
+	deferred   map[topicPartition]*deferral
+	dueWg      sync.WaitGroup
 }
 
-func New(client KafkaClient, processor processor.Processor, logger *zap.SugaredLogger) *Consumer {
-	return &Consumer{
+// deferral holds the records of a partition that wait for a retry record to be due.
+type deferral struct {
+	records []*kgo.Record
+	timer   *time.Timer
+}
+
+func New(client KafkaClient, processor processor.Processor, logger *zap.SugaredLogger, opts ...Option) *Consumer {
+	c := &Consumer{
 		client:    client,
 		processor: processor,
 		logger:    logger,
+		mode:      ModeConcurrent,
+		lanes:     defaultLanes,
+
+		maxPendingPerPartition: defaultMaxPendingPerPartition,
+		commitInterval:         defaultCommitInterval,
+
+		deferred: make(map[topicPartition]*deferral),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.exec = c.newExecutor()
+	c.throttle = newPartitionThrottle(client, c.maxInFlightPerPartition, c.maxPendingPerPartition, logger, c.instrumentor)
+	if c.offsets != nil {
+		c.offsets.OnForget(c.forget)
 	}
+	return c
+}
+
+// forget releases the lanes, pauses and deferred records kept for partitions that the tracker
+// forgot, so that they do not pile up as partitions move between consumers.
+func (c *Consumer) forget(partitions map[string][]int32) {
+	c.dropDeferred(partitions)
+	c.throttle.forget(partitions)
+	c.exec.forget(partitions)
 }
 
-func (c *Consumer) Run(ctx context.Context) {
+// Run polls and processes records until ctx is done or a fatal fetch error occurs, which is
+// returned as a *FetchError. Polling continues while earlier records are still being processed,
+// bounded by the concurrency and in-flight limits, and the records of healthy partitions are
+// processed even when fetching from others failed. On return, all in-flight records have
+// completed.
+func (c *Consumer) Run(ctx context.Context) error {
+	err := c.run(ctx)
+	if err != nil {
+		c.err.Store(&err)
+	}
+	return err
+}
+
+// Err returns the error that stopped Run, or nil. It can serve as a readiness check.
+func (c *Consumer) Err() error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (c *Consumer) run(ctx context.Context) error {
+	if c.transactor != nil {
+		return c.runTransactional(ctx)
+	}
+
+	exec := c.exec
+	limit := newLimiter(c.maxConcurrency, c.maxInFlightBytes)
+
+	if c.offsets != nil {
+		stopCommits := c.startCommitLoop(ctx)
+		defer func() {
+			exec.close()
+			stopCommits()
+			c.finalCommit()
+		}()
+	} else {
+		defer exec.close()
+	}
+
+	if c.bounds != nil {
+		defer c.startProgressLog()()
+	}
+
+	// Deferred records are admitted from timers, which must stop before the executor closes.
+	dueCtx, stopDue := context.WithCancel(ctx)
+	defer func() {
+		stopDue()
+		c.dropDeferred(nil)
+		c.dueWg.Wait()
+	}()
+	admit := func(record *kgo.Record) {
+		c.admit(ctx, exec, limit, record)
+	}
+
 	for {
 		if ctx.Err() != nil {
 			c.logger.Info("Context cancelled, stopping consumer poll loop.")
-			return
+			return nil
+		}
+		if c.bounds != nil && c.bounds.done() {
+			consumed, total := c.bounds.progress()
+			c.logger.Infow("All ranges consumed, stopping consumer poll loop.", "consumed", consumed, "total", total)
+			return nil
 		}
 
 		fetches := c.client.PollFetches(ctx)
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				c.logger.Errorw("Kafka fetch error", "topic", e.Topic, "partition", e.Partition, "error", e.Err)
+		fetchErr := c.checkFetchErrors(ctx, fetches)
+
+		fetches.EachRecord(func(record *kgo.Record) {
+			// Checked before the record takes any budget, so waiting retries hold up nothing but
+			// their own partition.
+			if c.deferUntilDue(dueCtx, record, admit) {
+				return
+			}
+			admit(record)
+		})
+
+		if fetchErr != nil {
+			return fetchErr
+		}
+	}
+}
+
+// admit hands rec to the executor once it fits in the in-flight budget.
+func (c *Consumer) admit(ctx context.Context, exec executor, limit *limiter, record *kgo.Record) {
+	if c.bounds != nil && !c.admitBounded(ctx, record) {
+		return
+	}
+	if c.offsets != nil {
+		// Records of a stopped partition that were fetched before it was paused are
+		// dropped; they are consumed again from the failed record.
+		if !c.offsets.Track(record) {
+			return
+		}
+		c.throttle.setPending(record, c.offsets.Pending(record.Topic, record.Partition))
+	}
+	// Blocking here stops polling until in-flight records free up budget.
+	size := recordSize(record)
+	if err := limit.acquire(ctx, size); err != nil {
+		return
+	}
+	c.instrumentInFlight(ctx, 1, size)
+	c.throttle.started(record)
+
+	exec.submit(record, func(rec *kgo.Record) {
+		defer limit.release(size)
+		defer c.instrumentInFlight(ctx, -1, -size)
+		defer c.throttle.finished(rec)
+		c.process(ctx, rec)
+		if c.offsets != nil {
+			c.throttle.setPending(rec, c.offsets.Pending(rec.Topic, rec.Partition))
+		}
+	})
+}
+
+func (c *Consumer) newExecutor() executor {
+	switch c.mode {
+	case ModePartition:
+		return newPartitionExecutor()
+	case ModeKey:
+		return newKeyExecutor(c.lanes, c.instrumentor)
+	default:
+		return &goroutineExecutor{}
+	}
+}
+
+func (c *Consumer) instrumentInFlight(ctx context.Context, records, bytes int64) {
+	if c.instrumentor != nil {
+		c.instrumentor.InstrumentInFlight(context.WithoutCancel(ctx), records, bytes)
+	}
+}
+
+// process runs the processor on rec, retrying failures according to the retry policy, and
+// reports whether the record was handled, either by the processor or by the failure handler.
+// ctx only governs the waits between attempts, so shutting down stops further retries
+// without cancelling an attempt that is already running. A record that waited in a lane while
+// its partition was stopped or revoked is dropped without being processed.
+func (c *Consumer) process(ctx context.Context, rec *kgo.Record) bool {
+	if c.offsets != nil && c.offsets.Stopped(rec) {
+		c.logger.Debugw("Dropped record queued behind a failed record or of a revoked partition", "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+		return false
+	}
+
+	recCtx := rec.Context
+	if recCtx == nil {
+		recCtx = context.Background()
+	}
+	if c.producer != nil {
+		recCtx = processor.ContextWithProducer(recCtx, c.producer)
+	}
+	if id := telemetry.CorrelationID(rec); id != "" {
+		recCtx = telemetry.ContextWithCorrelationID(recCtx, id)
+	}
+
+	attempts, err := c.retry.Do(ctx, func(attempt int) error {
+		return c.processAttempt(telemetry.ContextWithAttempt(recCtx, attempt), rec)
+	})
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			c.logger.Warnw("Stopped retrying record on shutdown", "attempts", attempts, "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+			return false
+		}
+		c.logger.Errorw("Failed to process record", "error", err, "attempts", attempts, "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+		if c.failureHandler == nil {
+			c.stopPartition(rec)
+			return false
+		}
+		if err = c.failureHandler.HandleFailure(recCtx, rec, err, attempts); err != nil {
+			c.logger.Errorw("Failed to hand off failed record", "error", err, "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+			c.stopPartition(rec)
+			return false
+		}
+	}
+	if c.offsets != nil {
+		c.offsets.Done(rec)
+	}
+	return true
+}
+
+// stopPartition stops consuming the partition of rec, which failed for good, so that its offsets
+// are not committed past it. Without an offset tracker there is nothing to protect: autocommit
+// moves on regardless, and a transaction is aborted instead.
+func (c *Consumer) stopPartition(rec *kgo.Record) {
+	if c.offsets == nil {
+		return
+	}
+	c.offsets.Fail(rec)
+	c.throttle.stop(rec)
+	c.logger.Errorw("Stopped consuming partition after a record failed, it resumes from this record once revoked or restarted", "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+}
+
+// processAttempt runs the processor once. A panic is recovered and returned as a
+// *processor.PanicError, so it only fails this record and goes through retries and failure
+// handling like any other error.
+func (c *Consumer) processAttempt(ctx context.Context, rec *kgo.Record) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := &processor.PanicError{Value: r, Stack: debug.Stack()}
+			c.logger.Errorw("Recovered panic while processing record", "panic", r, "stack", string(panicErr.Stack), "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+			if c.instrumentor != nil {
+				c.instrumentor.InstrumentPanic(ctx, rec, panicErr)
 			}
+			err = panicErr
+		}
+	}()
+	return c.processor.ProcessRecord(ctx, rec)
+}
+
+// deferUntilDue reports whether rec must not be processed yet: it comes from a retry topic and
+// is not due, or an earlier record of its partition was deferred. Such records are held in memory,
+// in fetch order, while their partition is paused, and handed to admit once due. Pausing bounds
+// the held records to those fetched before the partition was paused.
+func (c *Consumer) deferUntilDue(ctx context.Context, rec *kgo.Record, admit func(*kgo.Record)) bool {
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+
+	c.deferredMu.Lock()
+	defer c.deferredMu.Unlock()
+	if d, ok := c.deferred[tp]; ok {
+		d.records = append(d.records, rec)
+		return true
+	}
+	wait, ok := untilDue(rec)
+	if !ok {
+		return false
+	}
+
+	d := &deferral{records: []*kgo.Record{rec}}
+	c.deferred[tp] = d
+	c.throttle.hold(rec)
+	c.scheduleDue(ctx, tp, d, wait, admit)
+	c.logger.Debugw("Deferred retry record until due", "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset, "wait", wait)
+	return true
+}
+
+// untilDue returns how long rec has to wait before it is due, and false if it is due already.
+func untilDue(rec *kgo.Record) (time.Duration, bool) {
+	notBefore, ok := retrytopic.NotBefore(rec)
+	if !ok {
+		return 0, false
+	}
+	wait := time.Until(notBefore)
+	return wait, wait > 0
+}
+
+// scheduleDue admits the records held by d after wait. c.deferredMu must be held.
+func (c *Consumer) scheduleDue(ctx context.Context, tp topicPartition, d *deferral, wait time.Duration, admit func(*kgo.Record)) {
+	c.dueWg.Add(1)
+	d.timer = time.AfterFunc(wait, func() {
+		defer c.dueWg.Done()
+		c.admitDue(ctx, tp, d, admit)
+	})
+}
+
+// admitDue admits the records held by d in order, until one of them is not due yet, and resumes
+// the partition once none is left.
+func (c *Consumer) admitDue(ctx context.Context, tp topicPartition, d *deferral, admit func(*kgo.Record)) {
+	for ctx.Err() == nil {
+		c.deferredMu.Lock()
+		if c.deferred[tp] != d {
+			// The partition was forgotten.
+			c.deferredMu.Unlock()
+			return
+		}
+		if len(d.records) == 0 {
+			delete(c.deferred, tp)
+			c.deferredMu.Unlock()
+			c.throttle.release(&kgo.Record{Topic: tp.topic, Partition: tp.partition})
+			return
+		}
+		rec := d.records[0]
+		if wait, ok := untilDue(rec); ok {
+			c.scheduleDue(ctx, tp, d, wait, admit)
+			c.deferredMu.Unlock()
+			return
+		}
+		d.records[0] = nil
+		d.records = d.records[1:]
+		c.deferredMu.Unlock()
+
+		// Records the poll loop defers meanwhile queue up behind this one.
+		admit(rec)
+	}
+}
+
+// dropDeferred discards the records held for the given partitions, or for every partition if
+// none are passed, and lifts their hold. They are consumed again from the committed offset.
+func (c *Consumer) dropDeferred(partitions map[string][]int32) {
+	c.deferredMu.Lock()
+	defer c.deferredMu.Unlock()
+	for tp, d := range c.deferred {
+		if !contains(partitions, tp) {
 			continue
 		}
+		delete(c.deferred, tp)
+		if d.timer.Stop() {
+			c.dueWg.Done()
+		}
+		c.throttle.release(&kgo.Record{Topic: tp.topic, Partition: tp.partition})
+	}
+}
 
-		var wg sync.WaitGroup
-		fetches.EachRecord(func(record *kgo.Record) {
-			wg.Add(1)
-			go func(rec *kgo.Record) {
-				defer wg.Done()
-				if err := c.processor.ProcessRecord(rec.Context, rec); err != nil {
-					c.logger.Errorw("Failed to process record", "error", err, "topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+// startCommitLoop periodically commits tracked offsets until ctx is done or the returned
+// function is called. The returned function waits for an ongoing commit to finish.
+func (c *Consumer) startCommitLoop(ctx context.Context) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(c.commitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.offsets.Commit(ctx, c.client, nil); err != nil && ctx.Err() == nil {
+					c.logger.Warnw("Failed to commit offsets", "error", err)
 				}
-			}(record)
-		})
-		wg.Wait()
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (c *Consumer) finalCommit() {
+	ctx, cancel := context.WithTimeout(context.Background(), finalCommitTimeout)
+	defer cancel()
+
+	if err := c.offsets.Commit(ctx, c.client, nil); err != nil {
+		c.logger.Errorw("Failed to commit offsets on shutdown", "error", err)
+		return
 	}
+	c.logger.Debug("Final offset commit completed.")
 }
@@ -0,0 +1,212 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jdemon/ktel/processor"
+	"github.com/Jdemon/ktel/retrytopic"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// fakeClient is an in-memory KafkaClient. Every poll returns the next batch of records, and once
+// they run out polls block until the context is done.
+type fakeClient struct {
+	mu        sync.Mutex
+	batches   [][]*kgo.Record
+	polled    chan struct{}
+	paused    map[topicPartition]bool
+	committed map[string]map[int32]kgo.EpochOffset
+}
+
+func newFakeClient(batches ...[]*kgo.Record) *fakeClient {
+	return &fakeClient{
+		batches:   batches,
+		polled:    make(chan struct{}, len(batches)+1),
+		paused:    make(map[topicPartition]bool),
+		committed: make(map[string]map[int32]kgo.EpochOffset),
+	}
+}
+
+// fakeFetches is the result of a fakeClient poll.
+type fakeFetches []*kgo.Record
+
+func (f fakeFetches) Errors() []kgo.FetchError { return nil }
+
+func (f fakeFetches) EachRecord(fn func(*kgo.Record)) {
+	for _, rec := range f {
+		fn(rec)
+	}
+}
+
+func (c *fakeClient) PollFetches(ctx context.Context) Fetches {
+	c.mu.Lock()
+	if len(c.batches) > 0 {
+		batch := c.batches[0]
+		c.batches = c.batches[1:]
+		c.mu.Unlock()
+		return fakeFetches(batch)
+	}
+	c.mu.Unlock()
+
+	// Every batch was handed out and processed up to the executor.
+	select {
+	case c.polled <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return fakeFetches(nil)
+}
+
+func (c *fakeClient) PauseFetchPartitions(partitions map[string][]int32) map[string][]int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			c.paused[topicPartition{topic: topic, partition: partition}] = true
+		}
+	}
+	return partitions
+}
+
+func (c *fakeClient) ResumeFetchPartitions(partitions map[string][]int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			delete(c.paused, topicPartition{topic: topic, partition: partition})
+		}
+	}
+}
+
+func (c *fakeClient) CommitOffsets(_ context.Context, offsets map[string]map[int32]kgo.EpochOffset) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, partitions := range offsets {
+		if c.committed[topic] == nil {
+			c.committed[topic] = make(map[int32]kgo.EpochOffset)
+		}
+		for partition, eo := range partitions {
+			c.committed[topic][partition] = eo
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) Close() {}
+
+func (c *fakeClient) isPaused(topic string, partition int32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused[topicPartition{topic: topic, partition: partition}]
+}
+
+func TestConsumerDropsRecordsQueuedBehindFailure(t *testing.T) {
+	for _, mode := range []Mode{ModePartition, ModeKey} {
+		t.Run(string(mode), func(t *testing.T) {
+			client := newFakeClient([]*kgo.Record{
+				{Topic: "orders", Partition: 0, Offset: 0},
+				{Topic: "orders", Partition: 0, Offset: 1},
+				{Topic: "orders", Partition: 0, Offset: 2},
+			})
+			queued := make(chan struct{})
+			var mu sync.Mutex
+			var seen []int64
+			proc := processor.ProcessorFunc(func(_ context.Context, rec *kgo.Record) error {
+				mu.Lock()
+				seen = append(seen, rec.Offset)
+				mu.Unlock()
+				if rec.Offset == 0 {
+					// Fail only once the later records wait in the lane behind this one.
+					<-queued
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			c := New(client, proc, zap.NewNop().Sugar(), WithMode(mode), WithOffsetTracker(NewOffsetTracker()))
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- c.Run(ctx) }()
+
+			<-client.polled
+			close(queued)
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if want := []int64{0}; !reflect.DeepEqual(seen, want) {
+				t.Errorf("processed offsets = %v, want %v", seen, want)
+			}
+			if len(client.committed) != 0 {
+				t.Errorf("committed %v, want nothing", client.committed)
+			}
+			if !client.isPaused("orders", 0) {
+				t.Error("stopped partition was not paused")
+			}
+		})
+	}
+}
+
+func TestConsumerDefersRetryRecordsUntilDue(t *testing.T) {
+	notBefore := time.Now().Add(100 * time.Millisecond)
+	header := kgo.RecordHeader{Key: retrytopic.HeaderNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))}
+	client := newFakeClient([]*kgo.Record{
+		{Topic: "orders.retry", Partition: 0, Offset: 0, Headers: []kgo.RecordHeader{header}},
+		{Topic: "orders.retry", Partition: 0, Offset: 1},
+		{Topic: "orders.retry", Partition: 1, Offset: 0},
+	})
+
+	type processed struct {
+		partition int32
+		offset    int64
+		at        time.Time
+	}
+	seen := make(chan processed, 3)
+	proc := processor.ProcessorFunc(func(_ context.Context, rec *kgo.Record) error {
+		seen <- processed{rec.Partition, rec.Offset, time.Now()}
+		return nil
+	})
+
+	c := New(client, proc, zap.NewNop().Sugar(), WithMode(ModePartition), WithOffsetTracker(NewOffsetTracker()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	<-client.polled
+	if !client.isPaused("orders.retry", 0) {
+		t.Error("partition of a deferred record was not paused")
+	}
+
+	var got []processed
+	for range 3 {
+		got = append(got, <-seen)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got[0].partition != 1 {
+		t.Errorf("first processed record is from partition %d, want the partition without deferred records", got[0].partition)
+	}
+	if got[1].partition != 0 || got[1].offset != 0 || got[2].partition != 0 || got[2].offset != 1 {
+		t.Errorf("deferred partition processed out of order: %v", got[1:])
+	}
+	if got[1].at.Before(notBefore.Truncate(time.Millisecond)) {
+		t.Errorf("deferred record processed at %v, before it was due at %v", got[1].at, notBefore)
+	}
+	if client.isPaused("orders.retry", 0) {
+		t.Error("partition was not resumed once its records were due")
+	}
+	if want := (kgo.EpochOffset{Offset: 2}); client.committed["orders.retry"][0] != want {
+		t.Errorf("committed %v, want %v", client.committed["orders.retry"][0], want)
+	}
+}
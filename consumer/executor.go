@@ -0,0 +1,237 @@
+package consumer
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// executor schedules records for processing.
+type executor interface {
+	// submit schedules fn to be called with rec.
+	submit(rec *kgo.Record, fn func(*kgo.Record))
+	// forget releases what the executor holds for the given partitions, for example after they
+	// were revoked. Records of those partitions that were already submitted still run.
+	forget(partitions map[string][]int32)
+	// close stops the executor and waits for all submitted work to finish.
+	close()
+}
+
+// goroutineExecutor processes every record in its own goroutine.
+type goroutineExecutor struct {
+	wg sync.WaitGroup
+}
+
+func (e *goroutineExecutor) submit(rec *kgo.Record, fn func(*kgo.Record)) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		fn(rec)
+	}()
+}
+
+func (e *goroutineExecutor) forget(map[string][]int32) {}
+
+func (e *goroutineExecutor) close() {
+	e.wg.Wait()
+}
+
+type job struct {
+	rec *kgo.Record
+	fn  func(*kgo.Record)
+}
+
+// lane processes submitted records sequentially, in submission order. Submitting never
+// blocks; the consumer bounds how many records can be queued through its limiter.
+type lane struct {
+	mu     sync.Mutex
+	queue  []job
+	closed bool
+	signal chan struct{}
+	done   chan struct{}
+}
+
+func newLane() *lane {
+	l := &lane{
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *lane) run() {
+	defer close(l.done)
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return
+			}
+			<-l.signal
+			continue
+		}
+		j := l.queue[0]
+		l.queue[0] = job{}
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+
+		j.fn(j.rec)
+	}
+}
+
+func (l *lane) submit(rec *kgo.Record, fn func(*kgo.Record)) {
+	l.mu.Lock()
+	l.queue = append(l.queue, job{rec: rec, fn: fn})
+	l.mu.Unlock()
+	l.notify()
+}
+
+func (l *lane) notify() {
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the lane once its queued records are processed, without waiting for them.
+func (l *lane) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.notify()
+}
+
+// stop waits for the queued records to be processed and stops the lane.
+func (l *lane) stop() {
+	l.close()
+	<-l.done
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionExecutor gives every partition its own lane, so records of a partition are
+// processed in offset order while different partitions are processed in parallel.
+type partitionExecutor struct {
+	mu    sync.Mutex
+	lanes map[topicPartition]*lane
+	// released counts the forgotten lanes that are still draining.
+	released sync.WaitGroup
+}
+
+func newPartitionExecutor() *partitionExecutor {
+	return &partitionExecutor{
+		lanes: make(map[topicPartition]*lane),
+	}
+}
+
+func (e *partitionExecutor) submit(rec *kgo.Record, fn func(*kgo.Record)) {
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+
+	e.mu.Lock()
+	l, ok := e.lanes[tp]
+	if !ok {
+		l = newLane()
+		e.lanes[tp] = l
+	}
+	e.mu.Unlock()
+
+	l.submit(rec, fn)
+}
+
+// forget closes and drops the lanes of the given partitions. A lane finishes its queued
+// records in the background; a partition that is assigned again gets a new lane.
+func (e *partitionExecutor) forget(partitions map[string][]int32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			tp := topicPartition{topic: topic, partition: partition}
+			l, ok := e.lanes[tp]
+			if !ok {
+				continue
+			}
+			delete(e.lanes, tp)
+			l.close()
+			e.released.Add(1)
+			go func() {
+				defer e.released.Done()
+				<-l.done
+			}()
+		}
+	}
+}
+
+func (e *partitionExecutor) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for tp, l := range e.lanes {
+		l.stop()
+		delete(e.lanes, tp)
+	}
+	e.released.Wait()
+}
+
+// keyExecutor hashes record keys onto a fixed set of lanes, so records sharing a key are
+// processed in order while different keys, even within one partition, run concurrently.
+// Records without a key are hashed by partition to keep their partition order.
+type keyExecutor struct {
+	lanes        []*lane
+	instrumentor *telemetry.Instrumentor
+}
+
+func newKeyExecutor(lanes int, instrumentor *telemetry.Instrumentor) *keyExecutor {
+	e := &keyExecutor{
+		lanes:        make([]*lane, lanes),
+		instrumentor: instrumentor,
+	}
+	for i := range e.lanes {
+		e.lanes[i] = newLane()
+	}
+	return e
+}
+
+func (e *keyExecutor) submit(rec *kgo.Record, fn func(*kgo.Record)) {
+	idx := e.laneFor(rec)
+	if e.instrumentor == nil {
+		e.lanes[idx].submit(rec, fn)
+		return
+	}
+
+	e.instrumentor.InstrumentLaneSubmit(context.Background(), idx)
+	e.lanes[idx].submit(rec, func(r *kgo.Record) {
+		startTime := time.Now()
+		defer e.instrumentor.InstrumentLaneComplete(context.Background(), idx, startTime)
+		fn(r)
+	})
+}
+
+func (e *keyExecutor) laneFor(rec *kgo.Record) int {
+	h := fnv.New32a()
+	if rec.Key != nil {
+		_, _ = h.Write(rec.Key)
+	} else {
+		_, _ = h.Write([]byte(rec.Topic))
+		_, _ = h.Write(binary.BigEndian.AppendUint32(nil, uint32(rec.Partition)))
+	}
+	return int(h.Sum32() % uint32(len(e.lanes)))
+}
+
+// forget does nothing: the lanes are shared by all partitions.
+func (e *keyExecutor) forget(map[string][]int32) {}
+
+func (e *keyExecutor) close() {
+	for _, l := range e.lanes {
+		l.stop()
+	}
+}
@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestExecutorsKeepPartitionOrder(t *testing.T) {
+	const topic = "orders"
+
+	tests := []struct {
+		name string
+		exec executor
+	}{
+		{name: "partition", exec: newPartitionExecutor()},
+		{name: "key", exec: newKeyExecutor(4, nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			seen := make(map[int32][]int64)
+			want := make(map[int32][]int64)
+			for offset := range int64(50) {
+				for partition := range int32(3) {
+					want[partition] = append(want[partition], offset)
+					tt.exec.submit(&kgo.Record{Topic: topic, Partition: partition, Offset: offset}, func(rec *kgo.Record) {
+						mu.Lock()
+						defer mu.Unlock()
+						seen[rec.Partition] = append(seen[rec.Partition], rec.Offset)
+					})
+				}
+			}
+			tt.exec.close()
+
+			if !reflect.DeepEqual(seen, want) {
+				t.Errorf("processed offsets = %v, want %v", seen, want)
+			}
+		})
+	}
+}
+
+func TestGoroutineExecutorCloseWaits(t *testing.T) {
+	exec := &goroutineExecutor{}
+	var mu sync.Mutex
+	processed := 0
+	for offset := range int64(20) {
+		exec.submit(&kgo.Record{Offset: offset}, func(*kgo.Record) {
+			mu.Lock()
+			defer mu.Unlock()
+			processed++
+		})
+	}
+	exec.close()
+
+	if processed != 20 {
+		t.Errorf("processed %d records, want 20", processed)
+	}
+}
+
+func TestPartitionExecutorForget(t *testing.T) {
+	exec := newPartitionExecutor()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var seen []int64
+	record := func(rec *kgo.Record) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, rec.Offset)
+	}
+
+	exec.submit(&kgo.Record{Topic: "orders", Partition: 0, Offset: 0}, func(rec *kgo.Record) {
+		<-release
+		record(rec)
+	})
+	exec.submit(&kgo.Record{Topic: "orders", Partition: 0, Offset: 1}, record)
+	exec.submit(&kgo.Record{Topic: "orders", Partition: 1, Offset: 0}, record)
+
+	exec.forget(map[string][]int32{"orders": {0}})
+	exec.mu.Lock()
+	lanes := len(exec.lanes)
+	exec.mu.Unlock()
+	if lanes != 1 {
+		t.Errorf("executor holds %d lanes after forget, want 1", lanes)
+	}
+
+	// A partition assigned again gets a new lane, and close waits for the released one.
+	exec.submit(&kgo.Record{Topic: "orders", Partition: 0, Offset: 5}, record)
+	close(release)
+	exec.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 4 {
+		t.Errorf("processed offsets = %v, want 4 records", seen)
+	}
+}
+
+func TestKeyExecutorLaneFor(t *testing.T) {
+	exec := newKeyExecutor(8, nil)
+	defer exec.close()
+
+	tests := []struct {
+		name string
+		a, b *kgo.Record
+		same bool
+	}{
+		{
+			name: "same key on different partitions",
+			a:    &kgo.Record{Topic: "orders", Partition: 0, Key: []byte("k")},
+			b:    &kgo.Record{Topic: "orders", Partition: 3, Key: []byte("k")},
+			same: true,
+		},
+		{
+			name: "no key hashes by partition",
+			a:    &kgo.Record{Topic: "orders", Partition: 2},
+			b:    &kgo.Record{Topic: "orders", Partition: 2, Offset: 9},
+			same: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exec.laneFor(tt.a) == exec.laneFor(tt.b); got != tt.same {
+				t.Errorf("same lane = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// FetchError is returned by Run when polling failed with an error that retrying cannot fix, such
+// as an authorization failure or a topic that does not exist.
+type FetchError struct {
+	Topic     string
+	Partition int32
+	Err       error
+}
+
+func (e *FetchError) Error() string {
+	if e.Topic == "" {
+		return fmt.Sprintf("fatal fetch error: %v", e.Err)
+	}
+	return fmt.Sprintf("fatal fetch error on %s[%d]: %v", e.Topic, e.Partition, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// isFatalFetchError reports whether err keeps occurring no matter how often the fetch is retried.
+// The client already retries unknown topics for a while before reporting them, so by the time
+// they surface the topic is considered missing.
+func isFatalFetchError(err error) bool {
+	switch {
+	case errors.Is(err, kgo.ErrClientClosed),
+		errors.Is(err, kerr.UnknownTopicOrPartition),
+		errors.Is(err, kerr.UnknownTopicID):
+		return true
+	}
+	var kafkaErr *kerr.Error
+	return errors.As(err, &kafkaErr) && !kafkaErr.Retriable
+}
+
+// checkFetchErrors logs and counts the errors of a poll and returns the first fatal one as a
+// *FetchError. Errors caused by ctx being done are ignored. The records of the poll that were
+// fetched successfully remain valid either way.
+func (c *Consumer) checkFetchErrors(ctx context.Context, fetches Fetches) error {
+	var fatal error
+	for _, e := range fetches.Errors() {
+		if ctx.Err() != nil && errors.Is(e.Err, ctx.Err()) {
+			continue
+		}
+
+		isFatal := isFatalFetchError(e.Err)
+		if c.instrumentor != nil {
+			c.instrumentor.InstrumentFetchError(context.WithoutCancel(ctx), e.Topic, e.Partition, isFatal)
+		}
+		if !isFatal {
+			c.logger.Warnw("Retryable Kafka fetch error", "topic", e.Topic, "partition", e.Partition, "error", e.Err)
+			continue
+		}
+		c.logger.Errorw("Fatal Kafka fetch error", "topic", e.Topic, "partition", e.Partition, "error", e.Err)
+		if fatal == nil {
+			fatal = &FetchError{Topic: e.Topic, Partition: e.Partition, Err: e.Err}
+		}
+	}
+	return fatal
+}
@@ -0,0 +1,79 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// limiter bounds the number of records and bytes being processed at once.
+// A zero limit disables the corresponding bound.
+type limiter struct {
+	mu         sync.Mutex
+	maxRecords int
+	maxBytes   int64
+	records    int
+	bytes      int64
+	released   chan struct{}
+}
+
+func newLimiter(maxRecords int, maxBytes int64) *limiter {
+	return &limiter{
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		released:   make(chan struct{}),
+	}
+}
+
+// acquire blocks until a record of the given size fits in the budget or ctx is done.
+func (l *limiter) acquire(ctx context.Context, size int64) error {
+	for {
+		l.mu.Lock()
+		if l.fits(size) {
+			l.records++
+			l.bytes += size
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// release returns a record of the given size to the budget and wakes up waiters.
+func (l *limiter) release(size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records--
+	l.bytes -= size
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// fits reports whether a record of the given size can start. A record larger than the
+// whole byte budget is still admitted once nothing else is in flight.
+func (l *limiter) fits(size int64) bool {
+	if l.maxRecords > 0 && l.records >= l.maxRecords {
+		return false
+	}
+	if l.maxBytes > 0 && l.records > 0 && l.bytes+size > l.maxBytes {
+		return false
+	}
+	return true
+}
+
+// recordSize returns the number of payload bytes a record holds in memory.
+func recordSize(rec *kgo.Record) int64 {
+	size := len(rec.Key) + len(rec.Value)
+	for _, h := range rec.Headers {
+		size += len(h.Key) + len(h.Value)
+	}
+	return int64(size)
+}
@@ -0,0 +1,88 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestLimiterFits(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRecords int
+		maxBytes   int64
+		held       []int64
+		size       int64
+		want       bool
+	}{
+		{name: "unbounded", held: []int64{100, 100}, size: 1000, want: true},
+		{name: "below record limit", maxRecords: 2, held: []int64{1}, size: 1, want: true},
+		{name: "at record limit", maxRecords: 2, held: []int64{1, 1}, size: 1, want: false},
+		{name: "within byte budget", maxBytes: 10, held: []int64{4}, size: 6, want: true},
+		{name: "over byte budget", maxBytes: 10, held: []int64{4}, size: 7, want: false},
+		{name: "oversized record with nothing in flight", maxBytes: 10, size: 50, want: true},
+		{name: "both limits, records exhausted", maxRecords: 1, maxBytes: 10, held: []int64{1}, size: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := newLimiter(tt.maxRecords, tt.maxBytes)
+			for _, size := range tt.held {
+				limit.records++
+				limit.bytes += size
+			}
+			if got := limit.fits(tt.size); got != tt.want {
+				t.Errorf("fits(%d) = %v, want %v", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterAcquireWaitsForRelease(t *testing.T) {
+	limit := newLimiter(1, 0)
+	ctx := context.Background()
+	if err := limit.acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- limit.acquire(ctx, 1) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire() returned while the budget was exhausted")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	limit.release(1)
+	if err := <-acquired; err != nil {
+		t.Errorf("acquire() after release error = %v", err)
+	}
+}
+
+func TestLimiterAcquireCancelled(t *testing.T) {
+	limit := newLimiter(1, 0)
+	if err := limit.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limit.acquire(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	rec := &kgo.Record{
+		Key:     []byte("key"),
+		Value:   []byte("value"),
+		Headers: []kgo.RecordHeader{{Key: "h", Value: []byte("vv")}},
+	}
+	if got := recordSize(rec); got != 11 {
+		t.Errorf("recordSize() = %d, want 11", got)
+	}
+}
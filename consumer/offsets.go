@@ -0,0 +1,224 @@
+package consumer
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Committer commits offsets for the consumer group.
+type Committer interface {
+	CommitOffsets(context.Context, map[string]map[int32]kgo.EpochOffset) error
+}
+
+// OffsetTracker tracks records from the moment they are handed to the processor until they
+// complete. Per partition it exposes a contiguous watermark: the offset just after the last
+// record for which it and every earlier record completed. Committing the watermark never
+// skips a record that is still in flight or that failed, even if later records finished first.
+// Once a record of a partition failed for good, the partition is stopped: its watermark stays
+// before the failed record and no further records are tracked until the partition is forgotten.
+type OffsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+	onForget   func(map[string][]int32)
+}
+
+type trackedOffset struct {
+	offset int64
+	epoch  int32
+	done   bool
+}
+
+type partitionOffsets struct {
+	// pending holds the tracked records that have not moved below the watermark, in offset order.
+	pending   []trackedOffset
+	watermark kgo.EpochOffset
+	committed int64
+	// stopped is set once a record of the partition failed for good, at offset failed.
+	stopped bool
+	failed  int64
+}
+
+// NewOffsetTracker creates a new OffsetTracker.
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{
+		partitions: make(map[topicPartition]*partitionOffsets),
+	}
+}
+
+// Track registers a record that is about to be processed. Records of a partition must be
+// tracked in offset order, which is the order they are fetched in. It reports false, without
+// tracking the record, if the partition was stopped; such a record must not be processed.
+func (t *OffsetTracker) Track(rec *kgo.Record) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = &partitionOffsets{committed: -1, watermark: kgo.EpochOffset{Epoch: -1, Offset: -1}}
+		t.partitions[tp] = p
+	}
+	if p.stopped {
+		return false
+	}
+	p.pending = append(p.pending, trackedOffset{offset: rec.Offset, epoch: rec.LeaderEpoch})
+	return true
+}
+
+// Done marks a tracked record as successfully processed and advances the watermark of its
+// partition as far as the contiguous run of completed records allows.
+func (t *OffsetTracker) Done(rec *kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[topicPartition{topic: rec.Topic, partition: rec.Partition}]
+	if !ok {
+		// The partition was revoked while the record was in flight.
+		return
+	}
+
+	i := sort.Search(len(p.pending), func(i int) bool { return p.pending[i].offset >= rec.Offset })
+	if i == len(p.pending) || p.pending[i].offset != rec.Offset {
+		return
+	}
+	p.pending[i].done = true
+
+	n := 0
+	for n < len(p.pending) && p.pending[n].done {
+		n++
+	}
+	if n > 0 {
+		last := p.pending[n-1]
+		p.watermark = kgo.EpochOffset{Epoch: last.epoch, Offset: last.offset + 1}
+		p.pending = p.pending[n:]
+	}
+}
+
+// Fail marks a tracked record as failed for good and stops its partition. The watermark still
+// advances up to the failed record as the records before it complete, but never past it.
+func (t *OffsetTracker) Fail(rec *kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, ok := t.partitions[topicPartition{topic: rec.Topic, partition: rec.Partition}]; ok {
+		if !p.stopped || rec.Offset < p.failed {
+			p.failed = rec.Offset
+		}
+		p.stopped = true
+	}
+}
+
+// Stopped reports whether rec must no longer be processed: it comes after a record of its
+// partition that failed for good, or its partition was forgotten since it was tracked. Such
+// records may already wait in a lane; they are consumed again once the partition is reassigned
+// or the consumer restarts.
+func (t *OffsetTracker) Stopped(rec *kgo.Record) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[topicPartition{topic: rec.Topic, partition: rec.Partition}]
+	if !ok {
+		return true
+	}
+	return p.stopped && rec.Offset > p.failed
+}
+
+// Pending returns the number of tracked records of the partition that the watermark has not
+// moved past yet, completed or not.
+func (t *OffsetTracker) Pending(topic string, partition int32) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, ok := t.partitions[topicPartition{topic: topic, partition: partition}]; ok {
+		return len(p.pending)
+	}
+	return 0
+}
+
+// Uncommitted returns the watermark of every partition that advanced since its last commit.
+// If partitions is non-empty, only those partitions are considered.
+func (t *OffsetTracker) Uncommitted(partitions map[string][]int32) map[string]map[int32]kgo.EpochOffset {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+	for tp, p := range t.partitions {
+		if p.watermark.Offset <= p.committed || !contains(partitions, tp) {
+			continue
+		}
+		if offsets[tp.topic] == nil {
+			offsets[tp.topic] = make(map[int32]kgo.EpochOffset)
+		}
+		offsets[tp.topic][tp.partition] = p.watermark
+	}
+	return offsets
+}
+
+// MarkCommitted records that the given offsets were committed.
+func (t *OffsetTracker) MarkCommitted(offsets map[string]map[int32]kgo.EpochOffset) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, partitions := range offsets {
+		for partition, eo := range partitions {
+			if p, ok := t.partitions[topicPartition{topic: topic, partition: partition}]; ok && eo.Offset > p.committed {
+				p.committed = eo.Offset
+			}
+		}
+	}
+}
+
+// Forget drops all state for the given partitions, for example after they were revoked, which
+// also lifts the stop of a stopped partition. A partition that is assigned again starts over from
+// its committed offset.
+func (t *OffsetTracker) Forget(partitions map[string][]int32) {
+	t.mu.Lock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			delete(t.partitions, topicPartition{topic: topic, partition: partition})
+		}
+	}
+	onForget := t.onForget
+	t.mu.Unlock()
+
+	if onForget != nil {
+		onForget(partitions)
+	}
+}
+
+// OnForget registers fn to be called with the partitions passed to Forget, after their state
+// was dropped.
+func (t *OffsetTracker) OnForget(fn func(map[string][]int32)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onForget = fn
+}
+
+// Commit synchronously commits the uncommitted watermarks, limited to the given partitions
+// if any are passed.
+func (t *OffsetTracker) Commit(ctx context.Context, committer Committer, partitions map[string][]int32) error {
+	offsets := t.Uncommitted(partitions)
+	if len(offsets) == 0 {
+		return nil
+	}
+	if err := committer.CommitOffsets(ctx, offsets); err != nil {
+		return err
+	}
+	t.MarkCommitted(offsets)
+	return nil
+}
+
+func contains(partitions map[string][]int32, tp topicPartition) bool {
+	if len(partitions) == 0 {
+		return true
+	}
+	for _, p := range partitions[tp.topic] {
+		if p == tp.partition {
+			return true
+		}
+	}
+	return false
+}
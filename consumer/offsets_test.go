@@ -0,0 +1,285 @@
+package consumer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// trackerStep is one operation applied to an OffsetTracker in a table test.
+type trackerStep struct {
+	op        string // track, done, fail, commit or forget
+	partition int32
+	offset    int64
+}
+
+func TestOffsetTracker(t *testing.T) {
+	const topic = "orders"
+
+	tests := []struct {
+		name        string
+		steps       []trackerStep
+		uncommitted map[int32]int64
+		pending     map[int32]int
+		rejected    []int64
+	}{
+		{
+			name:        "nothing done",
+			steps:       []trackerStep{{"track", 0, 0}, {"track", 0, 1}},
+			uncommitted: map[int32]int64{},
+			pending:     map[int32]int{0: 2},
+		},
+		{
+			name:        "in order",
+			steps:       []trackerStep{{"track", 0, 0}, {"track", 0, 1}, {"done", 0, 0}, {"done", 0, 1}},
+			uncommitted: map[int32]int64{0: 2},
+			pending:     map[int32]int{0: 0},
+		},
+		{
+			name: "out of order stops at the first unfinished record",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2}, {"track", 0, 3},
+				{"done", 0, 0}, {"done", 0, 2}, {"done", 0, 3},
+			},
+			uncommitted: map[int32]int64{0: 1},
+			pending:     map[int32]int{0: 3},
+		},
+		{
+			name: "out of order catches up",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2},
+				{"done", 0, 2}, {"done", 0, 1}, {"done", 0, 0},
+			},
+			uncommitted: map[int32]int64{0: 3},
+			pending:     map[int32]int{0: 0},
+		},
+		{
+			name:        "gaps in offsets",
+			steps:       []trackerStep{{"track", 0, 5}, {"track", 0, 9}, {"done", 0, 9}, {"done", 0, 5}},
+			uncommitted: map[int32]int64{0: 10},
+		},
+		{
+			name: "partitions are independent",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 1, 0}, {"track", 1, 1},
+				{"done", 1, 1}, {"done", 0, 0},
+			},
+			uncommitted: map[int32]int64{0: 1},
+			pending:     map[int32]int{0: 0, 1: 2},
+		},
+		{
+			name:        "committed watermark is not returned again",
+			steps:       []trackerStep{{"track", 0, 0}, {"done", 0, 0}, {"commit", 0, 0}},
+			uncommitted: map[int32]int64{},
+		},
+		{
+			name: "advanced after commit",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"done", 0, 0}, {"commit", 0, 0}, {"done", 0, 1},
+			},
+			uncommitted: map[int32]int64{0: 2},
+		},
+		{
+			name:        "done for an untracked offset is ignored",
+			steps:       []trackerStep{{"track", 0, 1}, {"done", 0, 0}, {"done", 1, 1}},
+			uncommitted: map[int32]int64{},
+			pending:     map[int32]int{0: 1},
+		},
+		{
+			name: "failed record stops the partition",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2},
+				{"fail", 0, 1}, {"done", 0, 0}, {"done", 0, 2}, {"track", 0, 3},
+			},
+			uncommitted: map[int32]int64{0: 1},
+			pending:     map[int32]int{0: 2},
+			rejected:    []int64{3},
+		},
+		{
+			name: "revoked while in flight",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"done", 0, 0},
+				{"forget", 0, 0}, {"done", 0, 1},
+			},
+			uncommitted: map[int32]int64{},
+			pending:     map[int32]int{0: 0},
+		},
+		{
+			name: "revoked then reassigned starts over",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"done", 0, 0}, {"done", 0, 1}, {"commit", 0, 0},
+				{"forget", 0, 0},
+				{"track", 0, 2}, {"track", 0, 3}, {"done", 0, 3},
+			},
+			uncommitted: map[int32]int64{},
+			pending:     map[int32]int{0: 2},
+		},
+		{
+			name: "reassigned below the old watermark",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"track", 0, 1}, {"done", 0, 0}, {"done", 0, 1},
+				{"forget", 0, 0},
+				{"track", 0, 0}, {"done", 0, 0},
+			},
+			uncommitted: map[int32]int64{0: 1},
+		},
+		{
+			name: "revoke lifts the stop",
+			steps: []trackerStep{
+				{"track", 0, 0}, {"fail", 0, 0}, {"track", 0, 1},
+				{"forget", 0, 0},
+				{"track", 0, 0}, {"done", 0, 0},
+			},
+			uncommitted: map[int32]int64{0: 1},
+			rejected:    []int64{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewOffsetTracker()
+			var rejected []int64
+			for _, step := range tt.steps {
+				rec := &kgo.Record{Topic: topic, Partition: step.partition, Offset: step.offset}
+				switch step.op {
+				case "track":
+					if !tracker.Track(rec) {
+						rejected = append(rejected, step.offset)
+					}
+				case "done":
+					tracker.Done(rec)
+				case "fail":
+					tracker.Fail(rec)
+				case "commit":
+					tracker.MarkCommitted(tracker.Uncommitted(nil))
+				case "forget":
+					tracker.Forget(map[string][]int32{topic: {step.partition}})
+				default:
+					t.Fatalf("unknown op %q", step.op)
+				}
+			}
+
+			got := make(map[int32]int64)
+			for partition, eo := range tracker.Uncommitted(nil)[topic] {
+				got[partition] = eo.Offset
+			}
+			if !reflect.DeepEqual(got, tt.uncommitted) {
+				t.Errorf("Uncommitted() = %v, want %v", got, tt.uncommitted)
+			}
+			for partition, want := range tt.pending {
+				if got := tracker.Pending(topic, partition); got != want {
+					t.Errorf("Pending(%d) = %d, want %d", partition, got, want)
+				}
+			}
+			if !reflect.DeepEqual(rejected, tt.rejected) {
+				t.Errorf("rejected offsets = %v, want %v", rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerUncommittedFiltersPartitions(t *testing.T) {
+	tracker := NewOffsetTracker()
+	for _, rec := range []*kgo.Record{
+		{Topic: "a", Partition: 0, Offset: 0},
+		{Topic: "a", Partition: 1, Offset: 0},
+		{Topic: "b", Partition: 0, Offset: 0},
+	} {
+		tracker.Track(rec)
+		tracker.Done(rec)
+	}
+
+	got := tracker.Uncommitted(map[string][]int32{"a": {1}})
+	want := map[string]map[int32]kgo.EpochOffset{"a": {1: {Epoch: 0, Offset: 1}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Uncommitted() = %v, want %v", got, want)
+	}
+}
+
+func TestOffsetTrackerForgetNotifies(t *testing.T) {
+	tracker := NewOffsetTracker()
+	var forgotten map[string][]int32
+	tracker.OnForget(func(partitions map[string][]int32) {
+		forgotten = partitions
+	})
+
+	revoked := map[string][]int32{"orders": {0, 1}}
+	tracker.Forget(revoked)
+	if !reflect.DeepEqual(forgotten, revoked) {
+		t.Errorf("OnForget got %v, want %v", forgotten, revoked)
+	}
+}
+
+func TestOffsetTrackerStopped(t *testing.T) {
+	const topic = "orders"
+
+	tests := []struct {
+		name      string
+		steps     []trackerStep
+		partition int32
+		offset    int64
+		stopped   bool
+	}{
+		{
+			name:    "running partition",
+			steps:   []trackerStep{{"track", 0, 0}, {"track", 0, 1}},
+			offset:  1,
+			stopped: false,
+		},
+		{
+			name:    "after the failed record",
+			steps:   []trackerStep{{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2}, {"fail", 0, 0}},
+			offset:  2,
+			stopped: true,
+		},
+		{
+			name:    "before the failed record",
+			steps:   []trackerStep{{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2}, {"fail", 0, 1}},
+			offset:  0,
+			stopped: false,
+		},
+		{
+			name:    "earliest failure wins",
+			steps:   []trackerStep{{"track", 0, 0}, {"track", 0, 1}, {"track", 0, 2}, {"fail", 0, 2}, {"fail", 0, 0}},
+			offset:  1,
+			stopped: true,
+		},
+		{
+			name:    "forgotten partition",
+			steps:   []trackerStep{{"track", 0, 0}, {"track", 0, 1}, {"forget", 0, 0}},
+			offset:  1,
+			stopped: true,
+		},
+		{
+			name:      "other partition",
+			steps:     []trackerStep{{"track", 0, 0}, {"track", 1, 0}, {"track", 1, 1}, {"fail", 0, 0}},
+			partition: 1,
+			offset:    1,
+			stopped:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewOffsetTracker()
+			for _, step := range tt.steps {
+				rec := &kgo.Record{Topic: topic, Partition: step.partition, Offset: step.offset}
+				switch step.op {
+				case "track":
+					tracker.Track(rec)
+				case "fail":
+					tracker.Fail(rec)
+				case "forget":
+					tracker.Forget(map[string][]int32{topic: {step.partition}})
+				default:
+					t.Fatalf("unknown op %q", step.op)
+				}
+			}
+
+			if got := tracker.Stopped(&kgo.Record{Topic: topic, Partition: tt.partition, Offset: tt.offset}); got != tt.stopped {
+				t.Errorf("Stopped(%d) = %v, want %v", tt.offset, got, tt.stopped)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// partitionThrottle pauses fetching from individual partitions. A partition is paused once too
+// many of its records are in flight and resumed when half of them completed, so a slow partition
+// stops feeding the pipeline on its own instead of holding up the poll loop for every partition.
+// A partition is also kept paused while its records wait for a retry record to be due,
+// while too many of its offsets wait to be committed behind an unfinished record, and once it
+// was stopped after a record failed for good.
+type partitionThrottle struct {
+	client       KafkaClient
+	limit        int
+	maxPending   int
+	logger       *zap.SugaredLogger
+	instrumentor *telemetry.Instrumentor
+
+	mu       sync.Mutex
+	inFlight map[topicPartition]int
+	holds    map[topicPartition]int
+	pending  map[topicPartition]int
+	stopped  map[topicPartition]bool
+	paused   map[topicPartition]bool
+}
+
+func newPartitionThrottle(client KafkaClient, limit, maxPending int, logger *zap.SugaredLogger, instrumentor *telemetry.Instrumentor) *partitionThrottle {
+	return &partitionThrottle{
+		client:       client,
+		limit:        limit,
+		maxPending:   maxPending,
+		logger:       logger,
+		instrumentor: instrumentor,
+		inFlight:     make(map[topicPartition]int),
+		holds:        make(map[topicPartition]int),
+		pending:      make(map[topicPartition]int),
+		stopped:      make(map[topicPartition]bool),
+		paused:       make(map[topicPartition]bool),
+	}
+}
+
+// started registers a record of the partition entering the pipeline.
+func (t *partitionThrottle) started(rec *kgo.Record) {
+	t.adjust(rec, &t.inFlight, 1)
+}
+
+// finished registers a record of the partition leaving the pipeline.
+func (t *partitionThrottle) finished(rec *kgo.Record) {
+	t.adjust(rec, &t.inFlight, -1)
+}
+
+// hold keeps the partition of rec paused until release is called.
+func (t *partitionThrottle) hold(rec *kgo.Record) {
+	t.adjust(rec, &t.holds, 1)
+}
+
+// release undoes a previous hold.
+func (t *partitionThrottle) release(rec *kgo.Record) {
+	t.adjust(rec, &t.holds, -1)
+}
+
+// setPending records how many offsets of the partition of rec wait to be committed.
+func (t *partitionThrottle) setPending(rec *kgo.Record, pending int) {
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pending > 0 {
+		t.pending[tp] = pending
+	} else {
+		delete(t.pending, tp)
+	}
+	t.sync(tp)
+}
+
+// stop keeps the partition of rec paused until it is forgotten.
+func (t *partitionThrottle) stop(rec *kgo.Record) {
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped[tp] = true
+	t.sync(tp)
+}
+
+// forget lifts the stop and the pending offsets of the given partitions, for example after they
+// were revoked, and resumes them unless they are still paused for another reason.
+func (t *partitionThrottle) forget(partitions map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			tp := topicPartition{topic: topic, partition: partition}
+			delete(t.stopped, tp)
+			delete(t.pending, tp)
+			t.sync(tp)
+		}
+	}
+}
+
+func (t *partitionThrottle) adjust(rec *kgo.Record, counts *map[topicPartition]int, delta int) {
+	tp := topicPartition{topic: rec.Topic, partition: rec.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	(*counts)[tp] += delta
+	if (*counts)[tp] <= 0 {
+		delete(*counts, tp)
+	}
+	t.sync(tp)
+}
+
+// sync pauses or resumes the partition to match its in-flight records, pending offsets, holds
+// and stop.
+func (t *partitionThrottle) sync(tp topicPartition) {
+	overloaded := t.limit > 0 && (t.inFlight[tp] >= t.limit || (t.paused[tp] && t.inFlight[tp] > t.limit/2))
+	backlogged := t.maxPending > 0 && (t.pending[tp] >= t.maxPending || (t.paused[tp] && t.pending[tp] > t.maxPending/2))
+	pause := overloaded || backlogged || t.holds[tp] > 0 || t.stopped[tp]
+	if pause == t.paused[tp] {
+		return
+	}
+
+	partitions := map[string][]int32{tp.topic: {tp.partition}}
+	if pause {
+		t.paused[tp] = true
+		t.client.PauseFetchPartitions(partitions)
+		t.logger.Debugw("Paused fetching from partition", "topic", tp.topic, "partition", tp.partition, "inFlight", t.inFlight[tp], "pending", t.pending[tp], "holds", t.holds[tp], "stopped", t.stopped[tp])
+	} else {
+		delete(t.paused, tp)
+		t.client.ResumeFetchPartitions(partitions)
+		t.logger.Debugw("Resumed fetching from partition", "topic", tp.topic, "partition", tp.partition)
+	}
+	if t.instrumentor != nil {
+		t.instrumentor.InstrumentPartitionPause(context.Background(), tp.topic, tp.partition, pause)
+	}
+}
@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// throttleStep is one operation applied to a partitionThrottle in a table test.
+type throttleStep struct {
+	op string // started, finished, hold, release, pending, stop or forget
+	n  int    // the number of pending offsets, for pending
+}
+
+func TestPartitionThrottle(t *testing.T) {
+	const (
+		limit      = 4
+		maxPending = 10
+	)
+
+	tests := []struct {
+		name   string
+		steps  []throttleStep
+		paused bool
+	}{
+		{
+			name:   "below the in-flight limit",
+			steps:  []throttleStep{{"started", 0}, {"started", 0}, {"started", 0}},
+			paused: false,
+		},
+		{
+			name:   "at the in-flight limit",
+			steps:  []throttleStep{{"started", 0}, {"started", 0}, {"started", 0}, {"started", 0}},
+			paused: true,
+		},
+		{
+			name: "stays paused above half the limit",
+			steps: []throttleStep{
+				{"started", 0}, {"started", 0}, {"started", 0}, {"started", 0},
+				{"finished", 0},
+			},
+			paused: true,
+		},
+		{
+			name: "resumes at half the limit",
+			steps: []throttleStep{
+				{"started", 0}, {"started", 0}, {"started", 0}, {"started", 0},
+				{"finished", 0}, {"finished", 0},
+			},
+			paused: false,
+		},
+		{
+			name:   "backlogged",
+			steps:  []throttleStep{{"pending", 10}},
+			paused: true,
+		},
+		{
+			name:   "stays backlogged above half the pending limit",
+			steps:  []throttleStep{{"pending", 10}, {"pending", 6}},
+			paused: true,
+		},
+		{
+			name:   "backlog drained",
+			steps:  []throttleStep{{"pending", 10}, {"pending", 5}},
+			paused: false,
+		},
+		{
+			name:   "held",
+			steps:  []throttleStep{{"hold", 0}},
+			paused: true,
+		},
+		{
+			name:   "released",
+			steps:  []throttleStep{{"hold", 0}, {"hold", 0}, {"release", 0}, {"release", 0}},
+			paused: false,
+		},
+		{
+			name:   "stopped",
+			steps:  []throttleStep{{"stop", 0}},
+			paused: true,
+		},
+		{
+			name:   "stopped stays paused as records finish",
+			steps:  []throttleStep{{"started", 0}, {"stop", 0}, {"finished", 0}, {"pending", 0}},
+			paused: true,
+		},
+		{
+			name:   "forget lifts the stop and backlog",
+			steps:  []throttleStep{{"pending", 10}, {"stop", 0}, {"forget", 0}},
+			paused: false,
+		},
+		{
+			name:   "forget keeps a hold",
+			steps:  []throttleStep{{"hold", 0}, {"stop", 0}, {"forget", 0}},
+			paused: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient()
+			throttle := newPartitionThrottle(client, limit, maxPending, zap.NewNop().Sugar(), nil)
+			rec := &kgo.Record{Topic: "orders", Partition: 0}
+			for _, step := range tt.steps {
+				switch step.op {
+				case "started":
+					throttle.started(rec)
+				case "finished":
+					throttle.finished(rec)
+				case "hold":
+					throttle.hold(rec)
+				case "release":
+					throttle.release(rec)
+				case "pending":
+					throttle.setPending(rec, step.n)
+				case "stop":
+					throttle.stop(rec)
+				case "forget":
+					throttle.forget(map[string][]int32{rec.Topic: {rec.Partition}})
+				default:
+					t.Fatalf("unknown op %q", step.op)
+				}
+			}
+
+			if got := client.isPaused(rec.Topic, rec.Partition); got != tt.paused {
+				t.Errorf("paused = %v, want %v", got, tt.paused)
+			}
+			if other := client.isPaused(rec.Topic, 1); other {
+				t.Error("another partition was paused")
+			}
+		})
+	}
+}
+
+func TestPartitionThrottleUnbounded(t *testing.T) {
+	client := newFakeClient()
+	throttle := newPartitionThrottle(client, 0, 0, zap.NewNop().Sugar(), nil)
+	rec := &kgo.Record{Topic: "orders", Partition: 0}
+	for range 100 {
+		throttle.started(rec)
+	}
+	throttle.setPending(rec, 1000000)
+
+	if client.isPaused(rec.Topic, rec.Partition) {
+		t.Error("partition was paused without limits")
+	}
+}
@@ -0,0 +1,159 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// endTransactionTimeout bounds committing or aborting a transaction, including flushing the
+// records produced in it.
+const endTransactionTimeout = 30 * time.Second
+
+// Transactor begins and ends the transactions of an exactly-once consumer.
+type Transactor interface {
+	// Begin starts a transaction. Records produced until End belong to it.
+	Begin() error
+	// End commits the transaction together with the offsets of the polled records if commit is
+	// true, and aborts it otherwise. It reports whether the transaction was committed; an aborted
+	// transaction rewinds the consumer to the last committed offsets.
+	End(ctx context.Context, commit bool) (committed bool, err error)
+}
+
+// KgoTransactSessionAdapter adapts *kgo.GroupTransactSession to the KafkaClient and Transactor
+// interfaces. The session must be created with a transactional id.
+type KgoTransactSessionAdapter struct {
+	Session *kgo.GroupTransactSession
+}
+
+func (a *KgoTransactSessionAdapter) PollFetches(ctx context.Context) Fetches {
+	return a.Session.PollFetches(ctx)
+}
+
+func (a *KgoTransactSessionAdapter) PauseFetchPartitions(partitions map[string][]int32) map[string][]int32 {
+	return a.Session.Client().PauseFetchPartitions(partitions)
+}
+
+func (a *KgoTransactSessionAdapter) ResumeFetchPartitions(partitions map[string][]int32) {
+	a.Session.Client().ResumeFetchPartitions(partitions)
+}
+
+// CommitOffsets commits offsets outside of a transaction. Exactly-once consumers never need it,
+// since offsets are committed by End.
+func (a *KgoTransactSessionAdapter) CommitOffsets(ctx context.Context, offsets map[string]map[int32]kgo.EpochOffset) error {
+	return (&KgoClientAdapter{Client: a.Session.Client()}).CommitOffsets(ctx, offsets)
+}
+
+func (a *KgoTransactSessionAdapter) Begin() error {
+	return a.Session.Begin()
+}
+
+func (a *KgoTransactSessionAdapter) End(ctx context.Context, commit bool) (bool, error) {
+	return a.Session.End(ctx, kgo.TransactionEndTry(commit))
+}
+
+// Close aborts an ongoing transaction, if any, and closes the client.
+func (a *KgoTransactSessionAdapter) Close() {
+	a.Session.Close()
+}
+
+// runTransactional polls and processes records until ctx is done, one transaction per polled
+// batch. Unlike Run, it waits for the whole batch to complete before polling again, because the
+// transaction commits the offsets of everything polled so far.
+func (c *Consumer) runTransactional(ctx context.Context) error {
+	exec := c.exec
+	defer exec.close()
+	limit := newLimiter(c.maxConcurrency, c.maxInFlightBytes)
+
+	aborts := 0
+	for {
+		if ctx.Err() != nil {
+			c.logger.Info("Context cancelled, stopping consumer poll loop.")
+			return nil
+		}
+
+		// Records are processed even when some partitions failed to fetch: skipping them would
+		// let the next commit move past them.
+		fetches := c.client.PollFetches(ctx)
+		fetchErr := c.checkFetchErrors(ctx, fetches)
+
+		var records []*kgo.Record
+		fetches.EachRecord(func(record *kgo.Record) {
+			records = append(records, record)
+		})
+		if len(records) == 0 {
+			if fetchErr != nil {
+				return fetchErr
+			}
+			continue
+		}
+
+		if err := c.transactor.Begin(); err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+
+		handled := c.processBatch(ctx, exec, limit, records)
+
+		endCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), endTransactionTimeout)
+		committed, err := c.transactor.End(endCtx, handled)
+		cancel()
+		switch {
+		case err != nil:
+			c.logger.Errorw("Failed to end transaction, batch will be consumed again", "error", err, "records", len(records))
+		case !committed:
+			c.logger.Warnw("Transaction aborted, batch will be consumed again", "records", len(records))
+		}
+		if fetchErr != nil {
+			return fetchErr
+		}
+		if err == nil && committed {
+			aborts = 0
+			continue
+		}
+
+		// Back off before consuming an aborted batch again, so a record that keeps failing does
+		// not spin the consumer.
+		aborts++
+		timer := time.NewTimer(c.retry.Backoff(aborts))
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// processBatch processes records and waits for all of them, reporting whether every record
+// was handled.
+func (c *Consumer) processBatch(ctx context.Context, exec executor, limit *limiter, records []*kgo.Record) bool {
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+
+	for _, record := range records {
+		size := recordSize(record)
+		if err := limit.acquire(ctx, size); err != nil {
+			failed.Store(true)
+			break
+		}
+		c.instrumentInFlight(ctx, 1, size)
+		c.throttle.started(record)
+
+		wg.Add(1)
+		exec.submit(record, func(rec *kgo.Record) {
+			defer wg.Done()
+			defer limit.release(size)
+			defer c.instrumentInFlight(ctx, -1, -size)
+			defer c.throttle.finished(rec)
+			if !c.process(ctx, rec) {
+				failed.Store(true)
+			}
+		})
+	}
+
+	wg.Wait()
+	return !failed.Load()
+}
@@ -0,0 +1,109 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jdemon/ktel/processor"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/goccy/go-json"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Store remembers the keys of processed records for a limited time.
+type Store interface {
+	// Seen reports whether key was marked and has not expired yet.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark remembers key for ttl.
+	Mark(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// KeyExtractor returns the deduplication key of a record, or "" if it has none.
+type KeyExtractor func(record *kgo.Record) (string, error)
+
+// HeaderKey uses the value of the record header with the given name as the key.
+func HeaderKey(name string) KeyExtractor {
+	return func(record *kgo.Record) (string, error) {
+		for _, h := range record.Headers {
+			if h.Key == name {
+				return string(h.Value), nil
+			}
+		}
+		return "", nil
+	}
+}
+
+// RecordKey uses the record key as the key.
+func RecordKey() KeyExtractor {
+	return func(record *kgo.Record) (string, error) {
+		return string(record.Key), nil
+	}
+}
+
+// JSONFieldKey uses a field of a JSON record value as the key. Nested fields are addressed with
+// dots, e.g. "meta.id". String fields are used as is, other values in their JSON encoding.
+func JSONFieldKey(path string) KeyExtractor {
+	fields := strings.Split(path, ".")
+	return func(record *kgo.Record) (string, error) {
+		data, ok, err := processor.LookupJSONField(record.Value, fields)
+		if err != nil || !ok {
+			return "", err
+		}
+
+		var key string
+		if err := json.Unmarshal(data, &key); err == nil {
+			return key, nil
+		}
+		if string(data) == "null" {
+			return "", nil
+		}
+		return string(data), nil
+	}
+}
+
+// Middleware returns a processor.Middleware that skips records whose key was already processed
+// successfully within ttl. Keys are marked in store only once the next processor succeeds, so
+// failed records are not skipped when they are consumed again. Records without a key are always
+// processed. Skipped records are marked on the span and counted if instrumentor is non-nil.
+//
+// Duplicates that are processed at the same time, before either was marked, are not detected;
+// use a key-ordered consumer mode to rule that out.
+func Middleware(store Store, extract KeyExtractor, ttl time.Duration, instrumentor *telemetry.Instrumentor) processor.Middleware {
+	return func(next processor.Processor) processor.Processor {
+		return processor.ProcessorFunc(func(ctx context.Context, record *kgo.Record) error {
+			key, err := extract(record)
+			if err != nil {
+				return &processor.DecodeError{Err: fmt.Errorf("failed to extract deduplication key: %w", err)}
+			}
+			if key == "" {
+				return next.ProcessRecord(ctx, record)
+			}
+
+			seen, err := store.Seen(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to look up deduplication key: %w", err)
+			}
+			if seen {
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("messaging.kafka.duplicate", true))
+				if instrumentor != nil {
+					instrumentor.InstrumentDuplicate(ctx, record)
+				}
+				return nil
+			}
+
+			if err := next.ProcessRecord(ctx, record); err != nil {
+				return err
+			}
+			// The record was processed, so failing it now would only cause the duplicate this
+			// middleware is meant to prevent.
+			if err := store.Mark(ctx, key, ttl); err != nil {
+				trace.SpanFromContext(ctx).RecordError(fmt.Errorf("failed to mark deduplication key: %w", err))
+			}
+			return nil
+		})
+	}
+}
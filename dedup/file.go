@@ -0,0 +1,210 @@
+package dedup
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// compactMinLines is the number of log lines below which a FileStore never compacts its log.
+const compactMinLines = 1024
+
+// FileStore is a Store embedded in the process that persists keys to an append-only log file, so
+// they survive restarts. All unexpired keys are also kept in memory. Expired keys are swept from
+// memory whenever the log grew by as many lines as there were live keys at the last sweep, and the
+// log is rewritten without expired and overwritten entries once they make up most of it.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]time.Time
+	lines   int
+	// sweepAt is the number of log lines at which expired keys are swept next.
+	sweepAt int
+}
+
+// fileEntry is a line of the log of a FileStore.
+type fileEntry struct {
+	Key     string `json:"k"`
+	Expires int64  `json:"e"` // unix milliseconds
+}
+
+// OpenFileStore opens the FileStore logged to path, creating the file if needed.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:    path,
+		entries: make(map[string]time.Time),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup store: %w", err)
+	}
+	s.file = file
+	s.scheduleSweep()
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dedup store: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry fileEntry
+		// A torn last line from a crash is skipped, losing at most that key.
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		s.lines++
+		expires := time.UnixMilli(entry.Expires)
+		if expires.After(now) {
+			s.entries[entry.Key] = expires
+		} else {
+			delete(s.entries, entry.Key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dedup store: %w", err)
+	}
+	return nil
+}
+
+// Seen reports whether key was marked and has not expired yet.
+func (s *FileStore) Seen(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(expires) {
+		delete(s.entries, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Mark remembers key for ttl and appends it to the log.
+func (s *FileStore) Mark(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if err := s.append(s.file, fileEntry{Key: key, Expires: expires.UnixMilli()}); err != nil {
+		return err
+	}
+	s.entries[key] = expires
+	s.lines++
+
+	if s.lines < s.sweepAt {
+		return nil
+	}
+	// Keys are mostly unique, so they rarely expire through Seen; without sweeping, neither the
+	// map nor the log would ever shrink.
+	s.sweep()
+	defer s.scheduleSweep()
+	if s.lines > compactMinLines && s.lines > 2*len(s.entries) {
+		return s.compact()
+	}
+	return nil
+}
+
+// sweep drops the expired keys from memory.
+func (s *FileStore) sweep() {
+	now := time.Now()
+	for key, expires := range s.entries {
+		if now.After(expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// scheduleSweep sets when to sweep next, once the log grew by as many lines as there are live
+// keys, so sweeping costs a constant amount per marked key.
+func (s *FileStore) scheduleSweep() {
+	s.sweepAt = s.lines + max(len(s.entries), compactMinLines)
+}
+
+func (s *FileStore) append(file *os.File, entry fileEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the log with the unexpired keys only, replacing the old log atomically.
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+
+	now := time.Now()
+	lines := 0
+	for key, expires := range s.entries {
+		if now.After(expires) {
+			delete(s.entries, key)
+			continue
+		}
+		if err := s.append(tmp, fileEntry{Key: key, Expires: expires.UnixMilli()}); err != nil {
+			tmp.Close()
+			return err
+		}
+		lines++
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen dedup store: %w", err)
+	}
+	s.file.Close()
+	s.file = file
+	s.lines = lines
+	return nil
+}
+
+// Close flushes the log to disk and closes it.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
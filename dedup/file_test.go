@@ -0,0 +1,60 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSweepsExpiredKeys(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "dedup.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	for i := range 10000 {
+		if err := store.Mark(ctx, fmt.Sprintf("key-%d", i), time.Nanosecond); err != nil {
+			t.Fatalf("Mark() error = %v", err)
+		}
+	}
+
+	if n := len(store.entries); n > 2*compactMinLines {
+		t.Errorf("store holds %d entries, want at most %d", n, 2*compactMinLines)
+	}
+	if store.lines > 2*compactMinLines {
+		t.Errorf("log has %d lines, want at most %d", store.lines, 2*compactMinLines)
+	}
+}
+
+func TestFileStoreKeepsLiveKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := range 3000 {
+		if err := store.Mark(ctx, fmt.Sprintf("key-%d", i), time.Hour); err != nil {
+			t.Fatalf("Mark() error = %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for _, key := range []string{"key-0", "key-2999"} {
+		if seen, err := reopened.Seen(ctx, key); err != nil || !seen {
+			t.Errorf("Seen(%q) = %v, %v, want true", key, seen, err)
+		}
+	}
+}
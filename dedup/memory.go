@@ -0,0 +1,69 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store that keeps at most a fixed number of keys, evicting the least
+// recently marked key first. Its keys are lost when the process stops.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of *memoryEntry, most recently marked first
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore holding up to capacity keys.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Seen reports whether key was marked and has not expired yet.
+func (s *MemoryStore) Seen(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(elem.Value.(*memoryEntry).expires) {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Mark remembers key for ttl, evicting the least recently marked key if the store is full.
+func (s *MemoryStore) Mark(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if elem, ok := s.entries[key]; ok {
+		elem.Value.(*memoryEntry).expires = expires
+		s.order.MoveToFront(elem)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, expires: expires})
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package dlq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers added to every dead-lettered record, next to the original headers.
+const (
+	HeaderOriginalTopic     = "ktel.dlq.original.topic"
+	HeaderOriginalPartition = "ktel.dlq.original.partition"
+	HeaderOriginalOffset    = "ktel.dlq.original.offset"
+	HeaderOriginalTimestamp = "ktel.dlq.original.timestamp"
+	HeaderError             = "ktel.dlq.error"
+	HeaderAttempts          = "ktel.dlq.attempts"
+	HeaderConsumerGroup     = "ktel.dlq.consumer.group"
+)
+
+// Producer defines the Kafka client operation the Publisher needs.
+type Producer interface {
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+}
+
+// Publisher publishes records whose processing failed for good to a dead-letter topic.
+type Publisher struct {
+	producer     Producer
+	topic        string
+	group        string
+	instrumentor *telemetry.Instrumentor
+}
+
+// NewPublisher creates a new Publisher that produces to topic on behalf of the consumer group.
+func NewPublisher(producer Producer, topic, group string, instrumentor *telemetry.Instrumentor) *Publisher {
+	return &Publisher{
+		producer:     producer,
+		topic:        topic,
+		group:        group,
+		instrumentor: instrumentor,
+	}
+}
+
+// Topic returns the dead-letter topic.
+func (p *Publisher) Topic() string {
+	return p.topic
+}
+
+// HandleFailure publishes rec to the dead-letter topic with its original key, value and headers,
+// and headers describing where it came from and why it failed.
+func (p *Publisher) HandleFailure(ctx context.Context, rec *kgo.Record, cause error, attempts int) error {
+	dlqRecord := &kgo.Record{
+		Topic:   p.topic,
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: p.headers(rec, cause, attempts),
+	}
+	err := p.producer.ProduceSync(ctx, dlqRecord).FirstErr()
+
+	trace.SpanFromContext(ctx).AddEvent("dead-letter publish", trace.WithAttributes(
+		attribute.String("messaging.kafka.dlq.topic", p.topic),
+		attribute.Int("messaging.kafka.attempts", attempts),
+		attribute.String("error.message", cause.Error()),
+		attribute.Bool("success", err == nil),
+	))
+	if p.instrumentor != nil {
+		p.instrumentor.InstrumentDeadLetter(ctx, rec, p.topic, err == nil)
+	}
+	return err
+}
+
+func (p *Publisher) headers(rec *kgo.Record, cause error, attempts int) []kgo.RecordHeader {
+	headers := make([]kgo.RecordHeader, 0, len(rec.Headers)+7)
+	headers = append(headers, rec.Headers...)
+	return append(headers,
+		kgo.RecordHeader{Key: HeaderOriginalTopic, Value: []byte(rec.Topic)},
+		kgo.RecordHeader{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(rec.Partition)))},
+		kgo.RecordHeader{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(rec.Offset, 10))},
+		kgo.RecordHeader{Key: HeaderOriginalTimestamp, Value: []byte(rec.Timestamp.UTC().Format(time.RFC3339Nano))},
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(cause.Error())},
+		kgo.RecordHeader{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kgo.RecordHeader{Key: HeaderConsumerGroup, Value: []byte(p.group)},
+	)
+}
@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"sync"
 
 	"github.com/Jdemon/ktel"
 	"github.com/Jdemon/ktel/processor"
-	"github.com/goccy/go-json"
 	"github.com/twmb/franz-go/pkg/kgo"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -29,7 +28,7 @@ func run() error {
 		return fmt.Errorf("failed to create application: %w", err)
 	}
 
-	return app.Start(NewExampleProcessor(app.Logger, app.KafkaClient))
+	return app.Start(NewExampleProcessor(app.Logger))
 }
 
 // ResultMessage defines the structure of the incoming Kafka message
@@ -40,40 +39,21 @@ type ResultMessage struct {
 
 // ExampleProcessor processes
 type ExampleProcessor struct {
-	logger      *zap.SugaredLogger
-	trxPool     *sync.Pool
-	KafkaClient *kgo.Client
+	logger *zap.SugaredLogger
 }
 
-// NewExampleProcessor creates a new NewExampleProcessor.
-func NewExampleProcessor(logger *zap.SugaredLogger, kafkaClient *kgo.Client) processor.Processor {
-	return &ExampleProcessor{
+// NewExampleProcessor creates a new processor that decodes JSON result messages for ExampleProcessor.
+func NewExampleProcessor(logger *zap.SugaredLogger) processor.Processor {
+	p := &ExampleProcessor{
 		logger: logger,
-		trxPool: &sync.Pool{
-			New: func() interface{} {
-				return new(ResultMessage)
-			},
-		},
-		KafkaClient: kafkaClient,
 	}
+	return processor.Typed(processor.JSON[ResultMessage](), p.ProcessMessage)
 }
 
-// ProcessRecord processes a single Kafka record for e-withholding.
-func (p *ExampleProcessor) ProcessRecord(ctx context.Context, record *kgo.Record) (err error) {
+// ProcessMessage processes a single decoded Kafka record for e-withholding.
+func (p *ExampleProcessor) ProcessMessage(ctx context.Context, _ *kgo.Record, msg ResultMessage) (err error) {
 	span := trace.SpanFromContext(ctx)
 
-	msg := p.trxPool.Get().(*ResultMessage)
-	defer func() {
-		*msg = ResultMessage{} // Reset struct for reuse
-		p.trxPool.Put(msg)
-	}()
-
-	if err = p.unmarshalMessage(record, msg); err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to unmarshal message")
-		return err
-	}
-
 	span.SetAttributes(
 		attribute.String("transaction.ref", msg.TransactionRef),
 		attribute.String("ddp.result.code", msg.Code),
@@ -85,7 +65,12 @@ func (p *ExampleProcessor) ProcessRecord(ctx context.Context, record *kgo.Record
 		Topic: "result.topic",
 		Value: []byte("value"),
 	}
-	if err = p.KafkaClient.ProduceSync(ctx, resultRecord).FirstErr(); err != nil {
+	// With kafka.transactions enabled, the result is committed atomically with the consumed offset.
+	producer, ok := processor.ProducerFromContext(ctx)
+	if !ok {
+		return errors.New("no producer in context")
+	}
+	if err = producer.ProduceSync(ctx, resultRecord).FirstErr(); err != nil {
 		return err
 	}
 
@@ -93,14 +78,6 @@ func (p *ExampleProcessor) ProcessRecord(ctx context.Context, record *kgo.Record
 	return nil
 }
 
-func (p *ExampleProcessor) unmarshalMessage(record *kgo.Record, msg *ResultMessage) error {
-	if err := json.Unmarshal(record.Value, msg); err != nil {
-		p.logger.Errorw("Failed to unmarshal message", "error", err, "message", string(record.Value))
-		return err
-	}
-	return nil
-}
-
-func (p *ExampleProcessor) logMessage(msg *ResultMessage) {
+func (p *ExampleProcessor) logMessage(msg ResultMessage) {
 	p.logger.Debugw("Consumed message successfully", "transactionRef", msg.TransactionRef, "status", msg.Code)
 }
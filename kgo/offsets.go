@@ -0,0 +1,241 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Jdemon/ktel/config"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// resetOffset returns where partitions without committed offsets start, as configured in
+// kafka.offsets.reset.
+func resetOffset(cfg *config.Config) kgo.Offset {
+	switch cfg.Kafka.Offsets.Reset {
+	case "latest":
+		return kgo.NewOffset().AtEnd()
+	case "timestamp":
+		return kgo.NewOffset().AfterMilli(cfg.StartTimestamp().UnixMilli())
+	default:
+		return kgo.NewOffset().AtStart()
+	}
+}
+
+// resetMarkerSuffix names the companion group of a consumer group that records the timestamps
+// the group was reset to.
+const resetMarkerSuffix = ".ktel-reset"
+
+// groupAdmin is the part of *kadm.Client used to prepare group offsets.
+type groupAdmin interface {
+	DescribeGroups(ctx context.Context, groups ...string) (kadm.DescribedGroups, error)
+	FetchOffsets(ctx context.Context, group string) (kadm.OffsetResponses, error)
+	CommitOffsets(ctx context.Context, group string, os kadm.Offsets) (kadm.OffsetResponses, error)
+	ListOffsetsAfterMilli(ctx context.Context, millisecond int64, topics ...string) (kadm.ListedOffsets, error)
+	ListTopics(ctx context.Context, topics ...string) (kadm.TopicDetails, error)
+}
+
+// PrepareGroupOffsets commits the start offsets of kafka.offsets for the consumer group before it
+// is joined. With resetGroupToTimestamp, every partition of the consumed topics is moved to the
+// first offset at or after the timestamp. Explicit partition offsets are committed for partitions
+// that had no committed offset, and take precedence over the timestamp.
+//
+// The reset happens once per timestamp: the applied timestamp is recorded as commit metadata in
+// the companion group "<groupId>.ktel-reset", and later starts with the same timestamp leave the
+// group alone. Offsets can only be committed this way while the group has no active members; if
+// it has some, for example because other instances are already running, nothing is committed and
+// startup continues. The client options among opts are applied to the admin client.
+func PrepareGroupOffsets(ctx context.Context, cfg *config.Config, opts ...kgo.Opt) error {
+	if !cfg.Kafka.Offsets.ResetGroupToTimestamp && len(cfg.Kafka.Offsets.Partitions) == 0 {
+		return nil
+	}
+
+	client, err := kgo.NewClient(adminOpts(cfg, opts)...)
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka admin client: %w", err)
+	}
+	defer client.Close()
+	return prepareGroupOffsets(ctx, kadm.NewClient(client), cfg)
+}
+
+func prepareGroupOffsets(ctx context.Context, admin groupAdmin, cfg *config.Config) error {
+	group := cfg.Kafka.GroupID
+	marker := resetMarker(cfg)
+
+	offsets := make(kadm.Offsets)
+	var reset kadm.Offsets
+	if cfg.Kafka.Offsets.ResetGroupToTimestamp {
+		applied, err := resetApplied(ctx, admin, group, marker)
+		if err != nil {
+			return err
+		}
+		if applied {
+			zap.S().Infow("Consumer group was already reset to the timestamp, not resetting it again", "group", group, "timestamp", cfg.Kafka.Offsets.Timestamp)
+		} else {
+			topics, err := consumedTopics(ctx, admin, cfg.ConsumeTopics(), cfg.Kafka.TopicPatterns)
+			if err != nil {
+				return err
+			}
+			listed, err := admin.ListOffsetsAfterMilli(ctx, cfg.StartTimestamp().UnixMilli(), topics...)
+			if err == nil {
+				err = listed.Error()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to list offsets after %s: %w", cfg.Kafka.Offsets.Timestamp, err)
+			}
+			reset = listed.Offsets()
+			offsets = listed.Offsets()
+		}
+	}
+
+	if len(cfg.Kafka.Offsets.Partitions) > 0 {
+		committed, err := admin.FetchOffsets(ctx, group)
+		if err != nil {
+			return fmt.Errorf("failed to fetch committed offsets: %w", err)
+		}
+		for _, p := range cfg.Kafka.Offsets.Partitions {
+			if c, ok := committed.Lookup(p.Topic, p.Partition); ok && c.Err == nil && c.At >= 0 {
+				zap.S().Infow("Keeping committed offset over configured start offset", "topic", p.Topic, "partition", p.Partition)
+				continue
+			}
+			// Add keeps the larger of two offsets, but the explicit one wins.
+			offsets.Delete(p.Topic, p.Partition)
+			offsets.AddOffset(p.Topic, p.Partition, p.Offset, -1)
+		}
+	}
+
+	if len(offsets) == 0 {
+		return nil
+	}
+	active, err := groupActive(ctx, admin, group)
+	if err != nil {
+		return err
+	}
+	if !active {
+		if reset != nil {
+			zap.S().Warnw("Resetting consumer group offsets to timestamp", "group", group, "timestamp", cfg.Kafka.Offsets.Timestamp, "offsets", offsets.KOffsets())
+		}
+		err = commitOffsets(ctx, admin, group, offsets)
+		// Members that joined in the meantime make the broker reject the commit.
+		active = isMemberError(err)
+	}
+	if active {
+		if reset != nil {
+			zap.S().Warnw("Consumer group has active members, so it was not reset to the timestamp; stop every member of the group and start again to reset it", "group", group, "timestamp", cfg.Kafka.Offsets.Timestamp)
+		} else {
+			zap.S().Infow("Consumer group has active members, so the configured start offsets were not committed", "group", group)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit group offsets: %w", err)
+	}
+
+	if reset != nil {
+		if err := recordReset(ctx, admin, group, marker, reset); err != nil {
+			return fmt.Errorf("consumer group was reset to the timestamp, but recording the reset failed, so the next start resets it again: %w", err)
+		}
+	}
+	return nil
+}
+
+// resetMarker returns the commit metadata that records a reset to the configured timestamp.
+func resetMarker(cfg *config.Config) string {
+	return "resetGroupToTimestamp=" + cfg.StartTimestamp().UTC().Format(time.RFC3339Nano)
+}
+
+// resetApplied reports whether group was already reset to the timestamp of marker. A recorded
+// reset is committed again, so that it does not expire while the option stays set.
+func resetApplied(ctx context.Context, admin groupAdmin, group, marker string) (bool, error) {
+	recorded, err := admin.FetchOffsets(ctx, group+resetMarkerSuffix)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch the recorded resets of the group: %w", err)
+	}
+	var applied kadm.Offsets
+	recorded.Each(func(o kadm.OffsetResponse) {
+		if o.Err == nil && o.Metadata == marker {
+			applied.Add(o.Offset)
+		}
+	})
+	if applied == nil {
+		return false, nil
+	}
+	if err := recordReset(ctx, admin, group, marker, applied); err != nil {
+		zap.S().Warnw("Failed to refresh the recorded reset of the consumer group", "group", group, "error", err)
+	}
+	return true, nil
+}
+
+// recordReset commits the offsets group was reset to in its companion group, with marker as
+// metadata.
+func recordReset(ctx context.Context, admin groupAdmin, group, marker string, reset kadm.Offsets) error {
+	var recorded kadm.Offsets
+	reset.Each(func(o kadm.Offset) {
+		o.Metadata = marker
+		recorded.Add(o)
+	})
+	return commitOffsets(ctx, admin, group+resetMarkerSuffix, recorded)
+}
+
+// commitOffsets commits offsets for group and returns the first error, of the request or of a
+// partition.
+func commitOffsets(ctx context.Context, admin groupAdmin, group string, offsets kadm.Offsets) error {
+	committed, err := admin.CommitOffsets(ctx, group, offsets)
+	if err != nil {
+		return err
+	}
+	return committed.Error()
+}
+
+// groupActive reports whether group has members.
+func groupActive(ctx context.Context, admin groupAdmin, group string) (bool, error) {
+	described, err := admin.DescribeGroups(ctx, group)
+	if err != nil {
+		return false, fmt.Errorf("failed to describe consumer group: %w", err)
+	}
+	g, ok := described[group]
+	if !ok || g.Err != nil {
+		return false, nil
+	}
+	return len(g.Members) > 0, nil
+}
+
+// isMemberError reports whether err is how the broker rejects an offset commit from outside the
+// group while it has members.
+func isMemberError(err error) bool {
+	return errors.Is(err, kerr.UnknownMemberID) || errors.Is(err, kerr.IllegalGeneration) ||
+		errors.Is(err, kerr.RebalanceInProgress) || errors.Is(err, kerr.StaleMemberEpoch)
+}
+
+// consumedTopics returns the given topics and the topics matching patterns. Without patterns,
+// the topics are returned as they are.
+func consumedTopics(ctx context.Context, admin groupAdmin, topics, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return topics, nil
+	}
+	expressions := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expressions = append(expressions, regexp.MustCompile(pattern))
+	}
+
+	details, err := admin.ListTopics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+	matched := slices.Clone(topics)
+	for _, topic := range details.Names() {
+		if slices.Contains(topics, topic) {
+			continue
+		}
+		if slices.ContainsFunc(expressions, func(re *regexp.Regexp) bool { return re.MatchString(topic) }) {
+			matched = append(matched, topic)
+		}
+	}
+	return matched, nil
+}
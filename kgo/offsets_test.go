@@ -0,0 +1,245 @@
+package kgo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Jdemon/ktel/config"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+const testGroup = "billing"
+
+// fakeAdmin is an in-memory groupAdmin holding the committed offsets of every group.
+type fakeAdmin struct {
+	committed map[string]kadm.Offsets
+	listed    kadm.ListedOffsets
+	// members is the number of active members of testGroup.
+	members int
+	// joinBeforeCommit makes a member join testGroup between describing it and committing.
+	joinBeforeCommit bool
+}
+
+func newFakeAdmin(listed map[int32]int64) *fakeAdmin {
+	a := &fakeAdmin{
+		committed: make(map[string]kadm.Offsets),
+		listed:    kadm.ListedOffsets{"orders": {}},
+	}
+	for partition, offset := range listed {
+		a.listed["orders"][partition] = kadm.ListedOffset{Topic: "orders", Partition: partition, Offset: offset, LeaderEpoch: -1}
+	}
+	return a
+}
+
+func (a *fakeAdmin) DescribeGroups(_ context.Context, groups ...string) (kadm.DescribedGroups, error) {
+	described := make(kadm.DescribedGroups)
+	for _, group := range groups {
+		g := kadm.DescribedGroup{Group: group, State: "Empty"}
+		if group == testGroup && a.members > 0 {
+			g.State = "Stable"
+			g.Members = make([]kadm.DescribedGroupMember, a.members)
+		}
+		described[group] = g
+	}
+	if a.joinBeforeCommit {
+		a.members++
+	}
+	return described, nil
+}
+
+func (a *fakeAdmin) FetchOffsets(_ context.Context, group string) (kadm.OffsetResponses, error) {
+	fetched := make(kadm.OffsetResponses)
+	a.committed[group].Each(func(o kadm.Offset) {
+		if fetched[o.Topic] == nil {
+			fetched[o.Topic] = make(map[int32]kadm.OffsetResponse)
+		}
+		fetched[o.Topic][o.Partition] = kadm.OffsetResponse{Offset: o}
+	})
+	return fetched, nil
+}
+
+func (a *fakeAdmin) CommitOffsets(_ context.Context, group string, os kadm.Offsets) (kadm.OffsetResponses, error) {
+	var err error
+	if group == testGroup && a.members > 0 {
+		err = kerr.UnknownMemberID
+	}
+	responses := make(kadm.OffsetResponses)
+	os.Each(func(o kadm.Offset) {
+		if responses[o.Topic] == nil {
+			responses[o.Topic] = make(map[int32]kadm.OffsetResponse)
+		}
+		responses[o.Topic][o.Partition] = kadm.OffsetResponse{Offset: o, Err: err}
+	})
+	if err == nil {
+		os.Each(func(o kadm.Offset) { a.put(group, o) })
+	}
+	return responses, nil
+}
+
+func (a *fakeAdmin) ListOffsetsAfterMilli(context.Context, int64, ...string) (kadm.ListedOffsets, error) {
+	return a.listed, nil
+}
+
+func (a *fakeAdmin) ListTopics(context.Context, ...string) (kadm.TopicDetails, error) {
+	return kadm.TopicDetails{}, nil
+}
+
+// groupOffsets returns the offsets committed for testGroup.
+func (a *fakeAdmin) groupOffsets() map[int32]int64 {
+	offsets := make(map[int32]int64)
+	a.committed[testGroup].Each(func(o kadm.Offset) {
+		offsets[o.Partition] = o.At
+	})
+	return offsets
+}
+
+// consume simulates members of testGroup committing offsets after the given ones.
+func (a *fakeAdmin) consume(offsets map[int32]int64) {
+	for partition, offset := range offsets {
+		a.put(testGroup, kadm.Offset{Topic: "orders", Partition: partition, At: offset, LeaderEpoch: -1, Metadata: "member-1"})
+	}
+}
+
+// put commits o for group, replacing what was committed before.
+func (a *fakeAdmin) put(group string, o kadm.Offset) {
+	committed := a.committed[group]
+	committed.Delete(o.Topic, o.Partition)
+	committed.Add(o)
+	a.committed[group] = committed
+}
+
+func testConfig(resetTo string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Kafka.Topic = "orders"
+	cfg.Kafka.GroupID = testGroup
+	if resetTo != "" {
+		cfg.Kafka.Offsets.ResetGroupToTimestamp = true
+		cfg.Kafka.Offsets.Timestamp = resetTo
+	}
+	return cfg
+}
+
+// addStartOffset adds an explicit start offset to cfg.
+func addStartOffset(cfg *config.Config, partition int32, offset int64) {
+	partitions := reflect.ValueOf(&cfg.Kafka.Offsets.Partitions).Elem()
+	partitions.Set(reflect.Append(partitions, reflect.Zero(partitions.Type().Elem())))
+	p := &cfg.Kafka.Offsets.Partitions[partitions.Len()-1]
+	p.Topic, p.Partition, p.Offset = "orders", partition, offset
+}
+
+func TestPrepareGroupOffsets(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              *config.Config
+		startOffsets     map[int32]int64
+		committed        map[int32]int64
+		members          int
+		joinBeforeCommit bool
+		want             map[int32]int64
+		recorded         bool
+	}{
+		{
+			name:     "reset to timestamp",
+			cfg:      testConfig("2024-01-31T00:00:00Z"),
+			want:     map[int32]int64{0: 10, 1: 20},
+			recorded: true,
+		},
+		{
+			name:      "reset overrides committed offsets",
+			cfg:       testConfig("2024-01-31T00:00:00Z"),
+			committed: map[int32]int64{0: 500, 1: 600},
+			want:      map[int32]int64{0: 10, 1: 20},
+			recorded:  true,
+		},
+		{
+			name:      "group not empty",
+			cfg:       testConfig("2024-01-31T00:00:00Z"),
+			committed: map[int32]int64{0: 500, 1: 600},
+			members:   2,
+			want:      map[int32]int64{0: 500, 1: 600},
+		},
+		{
+			name:             "member joins before the commit",
+			cfg:              testConfig("2024-01-31T00:00:00Z"),
+			committed:        map[int32]int64{0: 500, 1: 600},
+			joinBeforeCommit: true,
+			want:             map[int32]int64{0: 500, 1: 600},
+		},
+		{
+			name:         "start offsets for partitions without committed offsets",
+			cfg:          testConfig(""),
+			startOffsets: map[int32]int64{0: 3, 1: 4},
+			committed:    map[int32]int64{0: 500},
+			want:         map[int32]int64{0: 500, 1: 4},
+		},
+		{
+			name:         "start offsets take precedence over the reset",
+			cfg:          testConfig("2024-01-31T00:00:00Z"),
+			startOffsets: map[int32]int64{1: 4},
+			want:         map[int32]int64{0: 10, 1: 4},
+			recorded:     true,
+		},
+		{
+			name:         "start offsets with active members",
+			cfg:          testConfig(""),
+			startOffsets: map[int32]int64{1: 4},
+			members:      1,
+			want:         map[int32]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeAdmin(map[int32]int64{0: 10, 1: 20})
+			admin.members = tt.members
+			admin.joinBeforeCommit = tt.joinBeforeCommit
+			admin.consume(tt.committed)
+			for partition, offset := range tt.startOffsets {
+				addStartOffset(tt.cfg, partition, offset)
+			}
+
+			if err := prepareGroupOffsets(context.Background(), admin, tt.cfg); err != nil {
+				t.Fatalf("prepareGroupOffsets() error = %v", err)
+			}
+			if got := admin.groupOffsets(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("committed offsets = %v, want %v", got, tt.want)
+			}
+			if recorded := len(admin.committed[testGroup+resetMarkerSuffix]) > 0; recorded != tt.recorded {
+				t.Errorf("reset recorded = %v, want %v", recorded, tt.recorded)
+			}
+		})
+	}
+}
+
+func TestPrepareGroupOffsetsResetsOnce(t *testing.T) {
+	admin := newFakeAdmin(map[int32]int64{0: 10, 1: 20})
+	cfg := testConfig("2024-01-31T00:00:00Z")
+
+	if err := prepareGroupOffsets(context.Background(), admin, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := admin.groupOffsets(), map[int32]int64{0: 10, 1: 20}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("committed offsets after the reset = %v, want %v", got, want)
+	}
+
+	// The group consumes past the reset and restarts with the option still set.
+	admin.consume(map[int32]int64{0: 15, 1: 25})
+	if err := prepareGroupOffsets(context.Background(), admin, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := admin.groupOffsets(), map[int32]int64{0: 15, 1: 25}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed offsets after a restart = %v, want %v", got, want)
+	}
+
+	// A different timestamp is a new reset.
+	cfg.Kafka.Offsets.Timestamp = "2024-02-01T00:00:00Z"
+	admin.listed["orders"][0] = kadm.ListedOffset{Topic: "orders", Partition: 0, Offset: 12, LeaderEpoch: -1}
+	if err := prepareGroupOffsets(context.Background(), admin, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := admin.groupOffsets(), map[int32]int64{0: 12, 1: 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed offsets after a reset to a new timestamp = %v, want %v", got, want)
+	}
+}
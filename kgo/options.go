@@ -6,10 +6,13 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/Jdemon/ktel/config"
+	"github.com/Jdemon/ktel/consumer"
 	"github.com/Jdemon/ktel/health"
+	"github.com/Jdemon/ktel/retrytopic"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/twmb/franz-go/pkg/sasl/plain"
 	"github.com/twmb/franz-go/pkg/sasl/scram"
@@ -20,41 +23,63 @@ import (
 )
 
 // BuildKgoOptions builds the options for the franz-go Kafka client.
-func BuildKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, checker *health.Checker) []kgo.Opt {
+// When offsets is non-nil, autocommit is disabled and the tracked offsets of revoked
+// partitions are committed synchronously before the partitions are given up. With
+// kafka.transactions enabled, the options configure a transactional client that only reads
+// committed records, to be used by a kgo.GroupTransactSession.
+func BuildKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, checker *health.Checker, offsets *consumer.OffsetTracker) []kgo.Opt {
+	topics := cfg.ConsumeTopics()
+	if cfg.Kafka.RetryTopics.Enabled {
+		topics = append(topics, retrytopic.Topics(retrytopic.Tiers(cfg.BaseTopic(), cfg.Kafka.GroupID, cfg.Kafka.RetryTopics.Delays))...)
+	}
+
 	opts := []kgo.Opt{
-		kgo.RequiredAcks(kgo.AllISRAcks()),
-		kgo.SeedBrokers(strings.Split(cfg.Kafka.Brokers, ",")...),
 		kgo.ConsumerGroup(cfg.Kafka.GroupID),
-		kgo.ConsumeTopics(cfg.Kafka.Topic),
 		kgo.OnPartitionsAssigned(func(_ context.Context, c *kgo.Client, assigned map[string][]int32) {
 			zap.S().Infow("Partitions assigned", "partitions", assigned)
 			checker.SetReady(true)
 		}),
-		kgo.OnPartitionsRevoked(func(_ context.Context, c *kgo.Client, revoked map[string][]int32) {
+		kgo.OnPartitionsRevoked(func(ctx context.Context, c *kgo.Client, revoked map[string][]int32) {
 			zap.S().Infow("Partitions revoked", "partitions", revoked)
 			checker.SetReady(false)
+			if offsets != nil && len(revoked) > 0 {
+				if err := offsets.Commit(ctx, &consumer.KgoClientAdapter{Client: c}, revoked); err != nil {
+					zap.S().Errorw("Failed to commit offsets of revoked partitions", "partitions", revoked, "error", err)
+				}
+				offsets.Forget(revoked)
+			}
 		}),
 		kgo.OnPartitionsLost(func(_ context.Context, c *kgo.Client, lost map[string][]int32) {
 			zap.S().Warnw("Partitions lost", "partitions", lost)
 			checker.SetReady(false)
+			if offsets != nil {
+				offsets.Forget(lost)
+			}
 		}),
 		// Performance tuning options
 		kgo.FetchMaxBytes(1024 * 1024 * 5), // 5MB
 	}
 
-	if cfg.Otel.Enabled {
-		tracerOpts := []kotel.TracerOpt{
-			kotel.TracerProvider(tp),
-			kotel.TracerPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{})),
-		}
-		tracer := kotel.NewTracer(tracerOpts...)
-		kotelOps := []kotel.Opt{
-			kotel.WithTracer(tracer),
-		}
-		kotelService := kotel.NewKotel(kotelOps...)
-		opts = append(opts, kgo.WithHooks(kotelService.Hooks()...))
+	opts = append(opts, connectionOpts(cfg)...)
+	opts = append(opts, consumeTopicsOpts(topics, cfg.Kafka.TopicPatterns)...)
+	opts = append(opts, kgo.ConsumeResetOffset(resetOffset(cfg)))
+	opts = append(opts, producerOpts(cfg)...)
+
+	if offsets != nil {
+		opts = append(opts, kgo.DisableAutoCommit())
+	}
+
+	if cfg.Kafka.Transactions.Enabled {
+		opts = append(opts,
+			kgo.TransactionalID(cfg.Kafka.Transactions.TransactionalID),
+			kgo.TransactionTimeout(cfg.Kafka.Transactions.Timeout),
+			kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+			kgo.RequireStableFetchOffsets(),
+		)
 	}
 
+	opts = append(opts, otelOpts(tp)...)
+
 	switch strings.ToLower(cfg.Kafka.RebalanceStrategy) {
 	case "roundrobin":
 		opts = append(opts, kgo.Balancers(kgo.RoundRobinBalancer()))
@@ -66,6 +91,15 @@ func BuildKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, checker *h
 		zap.S().Warnf("Unknown or empty rebalance strategy '%s', using default.", cfg.Kafka.RebalanceStrategy)
 	}
 
+	return opts
+}
+
+// connectionOpts returns the options to reach the brokers: the seed brokers, TLS and SASL.
+func connectionOpts(cfg *config.Config) []kgo.Opt {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(strings.Split(cfg.Kafka.Brokers, ",")...),
+	}
+
 	if cfg.Kafka.TLS.Enabled {
 		tlsConfig, err := createTLSConfig(cfg.Kafka.TLS.CertFile, cfg.Kafka.TLS.KeyFile, cfg.Kafka.TLS.CAFile)
 		if err != nil {
@@ -75,13 +109,25 @@ func BuildKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, checker *h
 	}
 
 	if cfg.Kafka.SASL.Enabled {
+		// The credentials are resolved for every new connection, so rotated secrets are used
+		// on reconnect without a restart.
+		user := cfg.Kafka.SASL.Username
 		switch strings.ToUpper(cfg.Kafka.SASL.Mechanism) {
 		case "PLAIN":
-			opts = append(opts, kgo.SASL(plain.Auth{User: cfg.Kafka.SASL.Username, Pass: cfg.Kafka.SASL.Password}.AsMechanism()))
+			opts = append(opts, kgo.SASL(plain.Plain(func(ctx context.Context) (plain.Auth, error) {
+				pass, err := saslPassword(ctx, cfg)
+				return plain.Auth{User: user, Pass: pass}, err
+			})))
 		case "SCRAM-SHA-256":
-			opts = append(opts, kgo.SASL(scram.Auth{User: cfg.Kafka.SASL.Username, Pass: cfg.Kafka.SASL.Password}.AsSha256Mechanism()))
+			opts = append(opts, kgo.SASL(scram.Sha256(func(ctx context.Context) (scram.Auth, error) {
+				pass, err := saslPassword(ctx, cfg)
+				return scram.Auth{User: user, Pass: pass}, err
+			})))
 		case "SCRAM-SHA-512":
-			opts = append(opts, kgo.SASL(scram.Auth{User: cfg.Kafka.SASL.Username, Pass: cfg.Kafka.SASL.Password}.AsSha512Mechanism()))
+			opts = append(opts, kgo.SASL(scram.Sha512(func(ctx context.Context) (scram.Auth, error) {
+				pass, err := saslPassword(ctx, cfg)
+				return scram.Auth{User: user, Pass: pass}, err
+			})))
 		default:
 			zap.S().Fatalf("Unsupported SASL mechanism: %s", cfg.Kafka.SASL.Mechanism)
 		}
@@ -90,6 +136,130 @@ func BuildKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, checker *h
 	return opts
 }
 
+// adminOpts returns the options of the short-lived admin clients: the connection options
+// followed by the client options among extra, such as a custom dialer or SASL mechanism. Consumer,
+// group and producer options are left out, so that an admin client never joins the group.
+func adminOpts(cfg *config.Config, extra []kgo.Opt) []kgo.Opt {
+	opts := connectionOpts(cfg)
+	for _, opt := range extra {
+		switch opt.(type) {
+		case kgo.ConsumerOpt, kgo.GroupOpt, kgo.ProducerOpt:
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+// saslPassword returns the current SASL password, resolving its secret reference again if it was
+// configured as one.
+func saslPassword(ctx context.Context, cfg *config.Config) (string, error) {
+	pass, ok, err := cfg.ResolveSecret(ctx, "kafka.sasl.password")
+	if err != nil {
+		zap.S().Errorw("Failed to resolve SASL password", "error", err)
+		return "", err
+	}
+	if !ok {
+		return cfg.Kafka.SASL.Password, nil
+	}
+	return pass, nil
+}
+
+// BuildReplayKgoOptions builds the options for a franz-go Kafka client that consumes the given
+// offset ranges directly, without joining the consumer group. Control records are kept so that
+// the consumer sees every offset up to the end of a range.
+func BuildReplayKgoOptions(cfg *config.Config, tp *sdktrace.TracerProvider, ranges map[string]map[int32]consumer.OffsetRange) []kgo.Opt {
+	partitions := make(map[string]map[int32]kgo.Offset)
+	for topic, topicRanges := range ranges {
+		for partition, r := range topicRanges {
+			if r.Start >= r.End {
+				continue
+			}
+			if partitions[topic] == nil {
+				partitions[topic] = make(map[int32]kgo.Offset)
+			}
+			partitions[topic][partition] = kgo.NewOffset().At(r.Start)
+		}
+	}
+
+	opts := []kgo.Opt{
+		kgo.ConsumePartitions(partitions),
+		kgo.KeepControlRecords(),
+		kgo.FetchMaxBytes(1024 * 1024 * 5), // 5MB
+	}
+	opts = append(opts, connectionOpts(cfg)...)
+	opts = append(opts, producerOpts(cfg)...)
+	opts = append(opts, otelOpts(tp)...)
+	return opts
+}
+
+// otelOpts traces produced and consumed records with kotel when there is a tracer provider.
+func otelOpts(tp *sdktrace.TracerProvider) []kgo.Opt {
+	if tp == nil {
+		return nil
+	}
+	tracerOpts := []kotel.TracerOpt{
+		kotel.TracerProvider(tp),
+		kotel.TracerPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{})),
+	}
+	tracer := kotel.NewTracer(tracerOpts...)
+	kotelOps := []kotel.Opt{
+		kotel.WithTracer(tracer),
+	}
+	kotelService := kotel.NewKotel(kotelOps...)
+	return []kgo.Opt{kgo.WithHooks(kotelService.Hooks()...)}
+}
+
+// producerOpts tunes the producer as configured in kafka.producer.
+func producerOpts(cfg *config.Config) []kgo.Opt {
+	opts := []kgo.Opt{
+		kgo.ProducerLinger(cfg.Kafka.Producer.Linger),
+		kgo.ProducerBatchMaxBytes(cfg.Kafka.Producer.MaxMessageBytes),
+	}
+
+	switch cfg.Kafka.Producer.Acks {
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if !cfg.Kafka.Producer.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	switch cfg.Kafka.Producer.Compression {
+	case "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression(), kgo.NoCompression()))
+	}
+
+	return opts
+}
+
+// consumeTopicsOpts subscribes to the given topics and topic patterns. With any pattern, the client
+// consumes by regex, so literal topic names are turned into anchored, escaped expressions.
+func consumeTopicsOpts(topics, patterns []string) []kgo.Opt {
+	if len(patterns) == 0 {
+		return []kgo.Opt{kgo.ConsumeTopics(topics...)}
+	}
+
+	expressions := make([]string, 0, len(topics)+len(patterns))
+	for _, topic := range topics {
+		expressions = append(expressions, "^"+regexp.QuoteMeta(topic)+"$")
+	}
+	expressions = append(expressions, patterns...)
+	return []kgo.Opt{kgo.ConsumeTopics(expressions...), kgo.ConsumeRegex()}
+}
+
 func createTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
 	if certFile != "" && keyFile != "" {
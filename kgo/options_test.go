@@ -0,0 +1,30 @@
+package kgo
+
+import (
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestAdminOpts(t *testing.T) {
+	cfg := testConfig("")
+	cfg.Kafka.Brokers = "localhost:9092"
+	base := len(connectionOpts(cfg))
+
+	opts := adminOpts(cfg, []kgo.Opt{
+		kgo.ClientID("billing-admin"),
+		kgo.ConsumerGroup("other"),
+		kgo.ConsumeTopics("payments"),
+		kgo.DefaultProduceTopic("payments"),
+		kgo.MaxVersions(nil),
+	})
+	if got, want := len(opts)-base, 2; got != want {
+		t.Fatalf("adminOpts() kept %d of the extra options, want %d", got, want)
+	}
+	for _, opt := range opts[base:] {
+		switch opt.(type) {
+		case kgo.ConsumerOpt, kgo.GroupOpt, kgo.ProducerOpt:
+			t.Errorf("adminOpts() kept %T", opt)
+		}
+	}
+}
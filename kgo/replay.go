@@ -0,0 +1,75 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Jdemon/ktel/config"
+	"github.com/Jdemon/ktel/consumer"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ReplayRanges resolves the offset ranges of kafka.replay. Explicit partition ranges are used as
+// configured. Otherwise every partition of the consumed topics is replayed from the first offset
+// at or after kafka.replay.from, or the start offset, up to the first offset at or after
+// kafka.replay.to, or the end offset at the time of the call. The client options among opts are
+// applied to the admin client that lists the offsets.
+func ReplayRanges(ctx context.Context, cfg *config.Config, opts ...kgo.Opt) (map[string]map[int32]consumer.OffsetRange, error) {
+	ranges := make(map[string]map[int32]consumer.OffsetRange)
+	if len(cfg.Kafka.Replay.Partitions) > 0 {
+		for _, p := range cfg.Kafka.Replay.Partitions {
+			if ranges[p.Topic] == nil {
+				ranges[p.Topic] = make(map[int32]consumer.OffsetRange)
+			}
+			ranges[p.Topic][p.Partition] = consumer.OffsetRange{Start: p.Start, End: p.End}
+		}
+		return ranges, nil
+	}
+
+	client, err := kgo.NewClient(adminOpts(cfg, opts)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka admin client: %w", err)
+	}
+	defer client.Close()
+	admin := kadm.NewClient(client)
+
+	topics, err := consumedTopics(ctx, admin, cfg.ConsumeTopics(), cfg.Kafka.TopicPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := cfg.ReplayWindow()
+	var starts, ends kadm.ListedOffsets
+	if from.IsZero() {
+		starts, err = admin.ListStartOffsets(ctx, topics...)
+	} else {
+		starts, err = admin.ListOffsetsAfterMilli(ctx, from.UnixMilli(), topics...)
+	}
+	if err == nil {
+		err = starts.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replay start offsets: %w", err)
+	}
+	if to.IsZero() {
+		ends, err = admin.ListEndOffsets(ctx, topics...)
+	} else {
+		ends, err = admin.ListOffsetsAfterMilli(ctx, to.UnixMilli(), topics...)
+	}
+	if err == nil {
+		err = ends.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replay end offsets: %w", err)
+	}
+
+	starts.Each(func(start kadm.ListedOffset) {
+		end, _ := ends.Lookup(start.Topic, start.Partition)
+		if ranges[start.Topic] == nil {
+			ranges[start.Topic] = make(map[int32]consumer.OffsetRange)
+		}
+		ranges[start.Topic][start.Partition] = consumer.OffsetRange{Start: start.Offset, End: end.Offset}
+	})
+	return ranges, nil
+}
@@ -0,0 +1,87 @@
+package ktel
+
+import (
+	"github.com/Jdemon/ktel/config"
+	"github.com/Jdemon/ktel/health"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+)
+
+// Option configures how New builds the application.
+type Option func(*options)
+
+type options struct {
+	cfg            *config.Config
+	configFile     string
+	logger         *zap.Logger
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *metric.MeterProvider
+	kgoOpts        []kgo.Opt
+	healthChecker  *health.Checker
+	settings       any
+}
+
+// WithConfig uses cfg instead of loading the configuration. cfg is validated by New, which also
+// fills in its derived values; config.Default is a convenient starting point.
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithConfigFile loads the configuration from the file at path instead of searching for
+// ktel-config.yaml. Environment variables still override it.
+func WithConfigFile(path string) Option {
+	return func(o *options) {
+		o.configFile = path
+	}
+}
+
+// WithLogger uses logger instead of the default JSON logger. It replaces the global zap logger,
+// which the Kafka client callbacks log to.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTracerProvider uses tp instead of creating a tracer provider from the otel configuration.
+// It is installed as the global provider and shut down with the application.
+func WithTracerProvider(tp *sdktrace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider uses mp instead of creating a meter provider from the otel configuration.
+// It is installed as the global provider and shut down with the application.
+func WithMeterProvider(mp *metric.MeterProvider) Option {
+	return func(o *options) {
+		o.meterProvider = mp
+	}
+}
+
+// WithKgoOptions appends opts to the options the Kafka client is created with, overriding the
+// ones derived from the configuration. The admin clients that prepare the group offsets and the
+// replay ranges get the options that are neither consumer, group nor producer options.
+func WithKgoOptions(opts ...kgo.Opt) Option {
+	return func(o *options) {
+		o.kgoOpts = append(o.kgoOpts, opts...)
+	}
+}
+
+// WithHealthChecker uses checker for the liveness and readiness probes instead of a new one.
+func WithHealthChecker(checker *health.Checker) Option {
+	return func(o *options) {
+		o.healthChecker = checker
+	}
+}
+
+// withSettings carries the application configuration settings loaded by NewWithConfig.
+func withSettings(settings any) Option {
+	return func(o *options) {
+		o.settings = settings
+	}
+}
@@ -25,32 +25,77 @@ func InitOtelProviders(cfg *config.Config) (*sdktrace.TracerProvider, *metric.Me
 
 	zap.S().Info("OpenTelemetry is enabled. Initializing providers...")
 
-	ctx := context.Background()
+	tp, err := NewTracerProvider(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	mp, err := NewMeterProvider(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	SetProviders(tp, mp)
 
-	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.AppName)))
+	return tp, mp, nil
+}
+
+// NewTracerProvider creates the tracer provider configured in otel, or returns nil if OpenTelemetry
+// is disabled. Unlike InitOtelProviders, it does not install the provider globally.
+func NewTracerProvider(cfg *config.Config) (*sdktrace.TracerProvider, error) {
+	if !cfg.Otel.Enabled {
+		return nil, nil
+	}
+	res, err := newResource(cfg)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create resource: %w", err)
+		return nil, err
 	}
 
-	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(cfg.Otel.Exporter.Grpc.Endpoint), otlptracegrpc.WithInsecure())
+	traceExporter, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpoint(cfg.Otel.Exporter.Grpc.Endpoint), otlptracegrpc.WithInsecure())
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
 	}
 
 	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
-	otel.SetTracerProvider(tp)
 	zap.S().Info("OpenTelemetry tracer provider initialized.")
+	return tp, nil
+}
+
+// NewMeterProvider creates the meter provider configured in otel, or returns nil if OpenTelemetry
+// is disabled. Unlike InitOtelProviders, it does not install the provider globally.
+func NewMeterProvider(cfg *config.Config) (*metric.MeterProvider, error) {
+	if !cfg.Otel.Enabled {
+		return nil, nil
+	}
+	res, err := newResource(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpoint(cfg.Otel.Exporter.Grpc.Endpoint), otlpmetricgrpc.WithInsecure())
+	metricExporter, err := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithEndpoint(cfg.Otel.Exporter.Grpc.Endpoint), otlpmetricgrpc.WithInsecure())
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
 	}
 
 	mp := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(metricExporter)), metric.WithResource(res))
-	otel.SetMeterProvider(mp)
 	zap.S().Info("OpenTelemetry meter provider initialized.")
+	return mp, nil
+}
 
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+func newResource(cfg *config.Config) (*resource.Resource, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.AppName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return res, nil
+}
 
-	return tp, mp, nil
+// SetProviders installs tracer and meter providers created elsewhere as the global providers,
+// together with the W3C trace context and baggage propagators. Nil providers are skipped.
+func SetProviders(tp *sdktrace.TracerProvider, mp *metric.MeterProvider) {
+	if tp != nil {
+		otel.SetTracerProvider(tp)
+	}
+	if mp != nil {
+		otel.SetMeterProvider(mp)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 }
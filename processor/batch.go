@@ -0,0 +1,209 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Jdemon/ktel/config"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchProcessor defines the interface for processing Kafka records in batches.
+// To fail only some records of a batch, return a *BatchError. Any other error fails every record.
+type BatchProcessor interface {
+	ProcessBatch(ctx context.Context, records []*kgo.Record) error
+}
+
+// BatchError reports the records of a batch that failed. Records without an entry succeeded.
+type BatchError struct {
+	Errors map[*kgo.Record]error
+}
+
+// NewBatchError creates an empty BatchError.
+func NewBatchError() *BatchError {
+	return &BatchError{Errors: make(map[*kgo.Record]error)}
+}
+
+// Fail records that processing rec failed with err.
+func (e *BatchError) Fail(rec *kgo.Record, err error) {
+	e.Errors[rec] = err
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d records of the batch failed", len(e.Errors))
+}
+
+// errFor returns the error of rec in the outcome of a batch.
+func errFor(err error, rec *kgo.Record) error {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.Errors[rec]
+	}
+	return err
+}
+
+// BatchingProcessor adapts a BatchProcessor to the Processor interface. Records handed to it
+// concurrently are gathered into a batch that is processed once it holds maxSize records or its
+// first record waited maxLinger. Every ProcessRecord call returns the outcome of its own record,
+// so partial failures are retried and dead-lettered like single-record failures.
+//
+// Batches only fill up when records are processed concurrently: in an ordered consumer mode a
+// lane contributes at most one record per batch.
+//
+// ProcessBatch gets a fresh context carrying only what every record of a batch shares: the
+// producer of ProducerFromContext and the application settings. Nothing specific to a record,
+// such as its trace parent, correlation id or attempt, is passed on; the batch span links to the
+// producer span of every record instead.
+type BatchingProcessor struct {
+	processor BatchProcessor
+	maxSize   int
+	maxLinger time.Duration
+
+	mu      sync.Mutex
+	pending *batch
+}
+
+type batch struct {
+	ctx     context.Context
+	records []*kgo.Record
+	timer   *time.Timer
+	done    chan struct{}
+	err     error
+}
+
+// NewBatchingProcessor creates a new BatchingProcessor.
+func NewBatchingProcessor(processor BatchProcessor, maxSize int, maxLinger time.Duration) *BatchingProcessor {
+	return &BatchingProcessor{
+		processor: processor,
+		maxSize:   max(maxSize, 1),
+		maxLinger: maxLinger,
+	}
+}
+
+// ProcessRecord adds the record to the current batch and waits for the batch to be processed.
+func (p *BatchingProcessor) ProcessRecord(ctx context.Context, record *kgo.Record) error {
+	p.mu.Lock()
+	b := p.pending
+	if b == nil {
+		b = &batch{ctx: batchContext(ctx), done: make(chan struct{})}
+		p.pending = b
+		b.timer = time.AfterFunc(p.maxLinger, func() { p.flush(b) })
+	}
+	b.records = append(b.records, record)
+	full := len(b.records) >= p.maxSize
+	p.mu.Unlock()
+
+	if full {
+		p.flush(b)
+	}
+	<-b.done
+	return errFor(b.err, record)
+}
+
+// batchContext returns the context a batch is processed with, carrying the producer and the
+// application settings of ctx.
+func batchContext(ctx context.Context) context.Context {
+	batchCtx := config.ContextWithSettingsOf(context.Background(), ctx)
+	if producer, ok := ProducerFromContext(ctx); ok {
+		batchCtx = ContextWithProducer(batchCtx, producer)
+	}
+	return batchCtx
+}
+
+// flush processes b unless it was already flushed.
+func (p *BatchingProcessor) flush(b *batch) {
+	p.mu.Lock()
+	if p.pending != b {
+		p.mu.Unlock()
+		return
+	}
+	p.pending = nil
+	b.timer.Stop()
+	p.mu.Unlock()
+
+	defer close(b.done)
+	// The flush may run on the linger timer's goroutine, where a panic would crash the process.
+	defer func() {
+		if r := recover(); r != nil {
+			b.err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	b.err = p.processor.ProcessBatch(b.ctx, b.records)
+}
+
+// InstrumentingBatchProcessor is a decorator that adds instrumentation to a BatchProcessor.
+// Every batch gets its own span, linked to the producer span of each of its records.
+type InstrumentingBatchProcessor struct {
+	processor    BatchProcessor
+	instrumentor *telemetry.Instrumentor
+	tracer       trace.Tracer
+}
+
+// NewInstrumentingBatchProcessor creates a new InstrumentingBatchProcessor.
+func NewInstrumentingBatchProcessor(processor BatchProcessor, instrumentor *telemetry.Instrumentor, tracer trace.Tracer) *InstrumentingBatchProcessor {
+	return &InstrumentingBatchProcessor{
+		processor:    processor,
+		instrumentor: instrumentor,
+		tracer:       tracer,
+	}
+}
+
+// ProcessBatch processes a batch of Kafka records and instruments the operation.
+func (p *InstrumentingBatchProcessor) ProcessBatch(ctx context.Context, records []*kgo.Record) (err error) {
+	ctx, span := p.tracer.Start(ctx, "batch process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(producerLinks(records)...),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(records))),
+	)
+	defer span.End()
+
+	startTime := time.Now()
+	defer func() {
+		failed := 0
+		var batchErr *BatchError
+		switch {
+		case errors.As(err, &batchErr):
+			failed = len(batchErr.Errors)
+		case err != nil:
+			failed = len(records)
+		}
+		if failed > 0 {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.Int("messaging.batch.failed_count", failed))
+		p.instrumentor.InstrumentBatch(ctx, len(records), failed, startTime)
+	}()
+
+	return p.processor.ProcessBatch(ctx, records)
+}
+
+// producerLinks returns links to the span contexts propagated in the headers of records.
+func producerLinks(records []*kgo.Record) []trace.Link {
+	propagator := otel.GetTextMapPropagator()
+	links := make([]trace.Link, 0, len(records))
+	for _, rec := range records {
+		sc := trace.SpanContextFromContext(propagator.Extract(context.Background(), telemetry.HeaderCarrier(rec.Headers)))
+		if !sc.IsValid() {
+			continue
+		}
+		links = append(links, trace.Link{
+			SpanContext: sc,
+			Attributes: []attribute.KeyValue{
+				attribute.String("messaging.kafka.topic", rec.Topic),
+				attribute.Int("messaging.kafka.partition", int(rec.Partition)),
+				attribute.Int64("messaging.kafka.offset", rec.Offset),
+			},
+		})
+	}
+	return links
+}
@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jdemon/ktel/config"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchFunc adapts a function to the BatchProcessor interface.
+type batchFunc func(ctx context.Context, records []*kgo.Record) error
+
+func (f batchFunc) ProcessBatch(ctx context.Context, records []*kgo.Record) error {
+	return f(ctx, records)
+}
+
+type nopProducer struct{}
+
+func (nopProducer) ProduceSync(context.Context, ...*kgo.Record) kgo.ProduceResults { return nil }
+
+func TestBatchingProcessorContext(t *testing.T) {
+	type settings struct{ Name string }
+
+	var batchCtx context.Context
+	p := NewBatchingProcessor(batchFunc(func(ctx context.Context, _ []*kgo.Record) error {
+		batchCtx = ctx
+		return nil
+	}), 1, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	}))
+	ctx = telemetry.ContextWithCorrelationID(ctx, "record-1")
+	ctx = telemetry.ContextWithAttempt(ctx, 3)
+	ctx = ContextWithProducer(ctx, nopProducer{})
+	ctx = config.ContextWithSettings(ctx, &settings{Name: "app"})
+
+	if err := p.ProcessRecord(ctx, &kgo.Record{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ProducerFromContext(batchCtx); !ok {
+		t.Error("batch context carries no producer")
+	}
+	if s, ok := config.SettingsFromContext[settings](batchCtx); !ok || s.Name != "app" {
+		t.Error("batch context carries no settings")
+	}
+	if trace.SpanContextFromContext(batchCtx).IsValid() {
+		t.Error("batch context carries the trace parent of the first record")
+	}
+	if id := telemetry.CorrelationIDFromContext(batchCtx); id != "" {
+		t.Errorf("batch context carries correlation id %q of the first record", id)
+	}
+	if attempt := telemetry.AttemptFromContext(batchCtx); attempt != 1 {
+		t.Errorf("batch context carries attempt %d of the first record", attempt)
+	}
+	cancel()
+	if batchCtx.Err() != nil {
+		t.Error("batch context is cancelled with the first record")
+	}
+}
+
+func TestBatchingProcessorPartialFailure(t *testing.T) {
+	errFailed := errors.New("failed")
+	records := []*kgo.Record{{Offset: 0}, {Offset: 1}}
+	p := NewBatchingProcessor(batchFunc(func(_ context.Context, batch []*kgo.Record) error {
+		batchErr := NewBatchError()
+		for _, rec := range batch {
+			if rec.Offset == 1 {
+				batchErr.Fail(rec, errFailed)
+			}
+		}
+		return batchErr
+	}), len(records), time.Second)
+
+	errs := make(chan error, len(records))
+	for _, rec := range records {
+		go func() { errs <- p.ProcessRecord(context.Background(), rec) }()
+	}
+	var failed int
+	for range records {
+		if err := <-errs; errors.Is(err, errFailed) {
+			failed++
+		} else if err != nil {
+			t.Errorf("ProcessRecord() error = %v", err)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d records failed, want 1", failed)
+	}
+}
@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Jdemon/ktel/retry"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/goccy/go-json"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrUnknownEventType is returned for records whose event type has no handler in a Dispatcher.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// EventTypeExtractor returns the event type of a record, or "" if it has none.
+type EventTypeExtractor func(record *kgo.Record) (string, error)
+
+// HeaderEventType extracts the event type from the record header with the given key.
+func HeaderEventType(key string) EventTypeExtractor {
+	return func(record *kgo.Record) (string, error) {
+		for _, h := range record.Headers {
+			if h.Key == key {
+				return string(h.Value), nil
+			}
+		}
+		return "", nil
+	}
+}
+
+// JSONFieldEventType extracts the event type from a string field of a JSON record value.
+// Nested fields are addressed with dots, e.g. "meta.type".
+func JSONFieldEventType(path string) EventTypeExtractor {
+	fields := strings.Split(path, ".")
+	return func(record *kgo.Record) (string, error) {
+		data, ok, err := LookupJSONField(record.Value, fields)
+		if err != nil || !ok {
+			return "", err
+		}
+
+		var eventType string
+		if err := json.Unmarshal(data, &eventType); err != nil {
+			return "", fmt.Errorf("field %q is not a string: %w", path, err)
+		}
+		return eventType, nil
+	}
+}
+
+// LookupJSONField returns the raw value of a field of the JSON object data, addressed by the
+// names of the nested fields leading to it, e.g. {"meta", "type"}. It reports false if there is
+// no such field.
+func LookupJSONField(data []byte, fields []string) ([]byte, bool, error) {
+	for _, field := range fields {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, false, err
+		}
+		var ok bool
+		if data, ok = object[field]; !ok {
+			return nil, false, nil
+		}
+	}
+	return data, true, nil
+}
+
+// FirstEventType returns the first non-empty event type found by extractors, in order.
+// It can be used to prefer a header and fall back to a payload field.
+func FirstEventType(extractors ...EventTypeExtractor) EventTypeExtractor {
+	return func(record *kgo.Record) (string, error) {
+		for _, extract := range extractors {
+			eventType, err := extract(record)
+			if err != nil || eventType != "" {
+				return eventType, err
+			}
+		}
+		return "", nil
+	}
+}
+
+// Dispatcher is a Processor that dispatches records to the Processor registered for their event
+// type. Records of an unregistered type go to the fallback Processor, if any, and are otherwise
+// handled according to the unknown-type policy. The event type is recorded on the span and, if
+// it is registered, as a metric dimension; unregistered types are counted as
+// telemetry.EventTypeUnknown. A failure to extract it is reported as a *DecodeError.
+type Dispatcher struct {
+	extract  EventTypeExtractor
+	handlers map[string]Processor
+	fallback Processor
+	unknown  UnmatchedPolicy
+}
+
+// NewDispatcher creates a new Dispatcher that applies unknown to records of unregistered types.
+func NewDispatcher(extract EventTypeExtractor, unknown UnmatchedPolicy) *Dispatcher {
+	return &Dispatcher{
+		extract:  extract,
+		handlers: make(map[string]Processor),
+		unknown:  unknown,
+	}
+}
+
+// Handle dispatches records of eventType to processor.
+func (d *Dispatcher) Handle(eventType string, processor Processor) {
+	d.handlers[eventType] = processor
+}
+
+// Fallback dispatches records of unregistered event types to processor.
+func (d *Dispatcher) Fallback(processor Processor) {
+	d.fallback = processor
+}
+
+// ProcessRecord processes a Kafka record with the Processor registered for its event type.
+func (d *Dispatcher) ProcessRecord(ctx context.Context, record *kgo.Record) error {
+	eventType, err := d.extract(record)
+	if err != nil {
+		return &DecodeError{Err: fmt.Errorf("failed to extract event type: %w", err)}
+	}
+	p, ok := d.handlers[eventType]
+	telemetry.SetEventType(ctx, eventType, ok)
+
+	if ok {
+		return p.ProcessRecord(ctx, record)
+	}
+	if d.fallback != nil {
+		return d.fallback.ProcessRecord(ctx, record)
+	}
+
+	switch d.unknown {
+	case UnmatchedError:
+		return fmt.Errorf("%w %q", ErrUnknownEventType, eventType)
+	case UnmatchedDLQ:
+		return retry.Permanent(fmt.Errorf("%w %q", ErrUnknownEventType, eventType))
+	default:
+		return nil
+	}
+}
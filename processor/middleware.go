@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Middleware decorates a Processor with additional behavior.
+type Middleware func(Processor) Processor
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(ctx context.Context, record *kgo.Record) error
+
+// ProcessRecord calls f(ctx, record).
+func (f ProcessorFunc) ProcessRecord(ctx context.Context, record *kgo.Record) error {
+	return f(ctx, record)
+}
+
+// Chain wraps processor with middlewares. The first middleware is the outermost one, so it sees
+// every record first and every result last.
+func Chain(processor Processor, middlewares ...Middleware) Processor {
+	for _, mw := range slices.Backward(middlewares) {
+		processor = mw(processor)
+	}
+	return processor
+}
+
+// Instrument returns a Middleware that traces and measures every record, see InstrumentingProcessor.
+func Instrument(instrumentor *telemetry.Instrumentor, tracer trace.Tracer) Middleware {
+	return func(next Processor) Processor {
+		return NewInstrumentingProcessor(next, instrumentor, tracer)
+	}
+}
+
+// PanicError is returned in place of a panic raised while processing a record.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while processing record: %v", e.Value)
+}
+
+// ErrorType classifies the error for telemetry.
+func (e *PanicError) ErrorType() string {
+	return "panic"
+}
+
+// Recover returns a Middleware that turns panics of the next processor into a *PanicError.
+func Recover() Middleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, record *kgo.Record) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next.ProcessRecord(ctx, record)
+		})
+	}
+}
+
+// Timeout returns a Middleware that cancels the context of the next processor after timeout.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, record *kgo.Record) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.ProcessRecord(ctx, record)
+		})
+	}
+}
+
+// Logging returns a Middleware that logs the outcome and duration of every record.
+func Logging(logger *zap.SugaredLogger) Middleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, record *kgo.Record) error {
+			startTime := time.Now()
+			err := next.ProcessRecord(ctx, record)
+
+			fields := []any{"topic", record.Topic, "partition", record.Partition, "offset", record.Offset, "duration", time.Since(startTime)}
+			if err != nil {
+				logger.Warnw("Record processing failed", append(fields, "error", err)...)
+			} else {
+				logger.Debugw("Record processed", fields...)
+			}
+			return err
+		})
+	}
+}
+
+// Filter returns a Middleware that only passes records for which keep returns true to the next
+// processor. Other records are skipped and count as processed.
+func Filter(keep func(record *kgo.Record) bool) Middleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, record *kgo.Record) error {
+			if !keep(record) {
+				return nil
+			}
+			return next.ProcessRecord(ctx, record)
+		})
+	}
+}
+
+// FilterHeader returns a Middleware that only passes records whose header key has one of the
+// given values to the next processor. Without values, the header only needs to be present.
+func FilterHeader(key string, values ...string) Middleware {
+	return Filter(func(record *kgo.Record) bool {
+		for _, h := range record.Headers {
+			if h.Key == key && (len(values) == 0 || slices.Contains(values, string(h.Value))) {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -3,6 +3,7 @@ package processor
 import (
 	"context"
 	"fmt"
+	"runtime/debug"
 	"time"
 
 	"github.com/Jdemon/ktel/telemetry"
@@ -36,10 +37,16 @@ func (p *InstrumentingProcessor) ProcessRecord(ctx context.Context, record *kgo.
 	spanName := fmt.Sprintf("%s process", record.Topic)
 	ctx, span := p.tracer.Start(ctx, spanName)
 	defer span.End()
+	ctx = telemetry.ContextWithRecordLabels(ctx)
 
 	startTime := time.Now()
 	defer func() {
-		p.instrumentor.InstrumentMessage(ctx, record, err == nil, startTime)
+		// Record a panic on the span before letting it continue to whoever recovers it.
+		if r := recover(); r != nil {
+			p.instrumentor.InstrumentMessage(ctx, record, &PanicError{Value: r, Stack: debug.Stack()}, startTime)
+			panic(r)
+		}
+		p.instrumentor.InstrumentMessage(ctx, record, err, startTime)
 	}()
 
 	return p.processor.ProcessRecord(ctx, record)
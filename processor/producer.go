@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Producer produces records from within a processor. When the consumer runs in exactly-once
+// mode, records produced through the Producer of a record's context belong to the transaction
+// of that record's batch: they become visible to read_committed consumers only together with
+// the commit of the consumed offsets, and are discarded if the batch is aborted.
+type Producer interface {
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+}
+
+type producerKey struct{}
+
+// ContextWithProducer returns a copy of ctx carrying producer.
+func ContextWithProducer(ctx context.Context, producer Producer) context.Context {
+	return context.WithValue(ctx, producerKey{}, producer)
+}
+
+// ProducerFromContext returns the Producer carried by ctx, if any. The consumer puts one into
+// the context of every record it processes.
+func ProducerFromContext(ctx context.Context) (Producer, bool) {
+	producer, ok := ctx.Value(producerKey{}).(Producer)
+	return producer, ok
+}
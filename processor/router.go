@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/Jdemon/ktel/retry"
+	"github.com/Jdemon/ktel/retrytopic"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrNoRoute is returned for records whose topic matches no route of a Router.
+var ErrNoRoute = errors.New("no route for topic")
+
+// UnmatchedPolicy decides what a Router or Dispatcher does with records it has no Processor for.
+type UnmatchedPolicy string
+
+const (
+	// UnmatchedSkip treats unmatched records as processed.
+	UnmatchedSkip UnmatchedPolicy = "skip"
+	// UnmatchedError fails unmatched records, so they are retried and then handed to the
+	// failure handler like any other failure.
+	UnmatchedError UnmatchedPolicy = "error"
+	// UnmatchedDLQ fails unmatched records with a permanent error, which skips retries and
+	// sends them straight to the dead-letter topic. It requires a dead-letter topic to be
+	// configured; without one, the record stops its partition like any other failed record.
+	UnmatchedDLQ UnmatchedPolicy = "dlq"
+)
+
+type patternRoute struct {
+	pattern   *regexp.Regexp
+	processor Processor
+}
+
+// Router is a Processor that dispatches records to the Processor registered for their topic.
+// Exact topic routes take precedence over pattern routes, which are tried in registration order.
+// Records consumed from a retry topic are routed by the topic they were originally consumed from.
+type Router struct {
+	routes    map[string]Processor
+	patterns  []patternRoute
+	unmatched UnmatchedPolicy
+}
+
+// NewRouter creates a new Router that applies unmatched to records without a route.
+func NewRouter(unmatched UnmatchedPolicy) *Router {
+	return &Router{
+		routes:    make(map[string]Processor),
+		unmatched: unmatched,
+	}
+}
+
+// Handle routes records of topic to processor.
+func (r *Router) Handle(topic string, processor Processor) {
+	r.routes[topic] = processor
+}
+
+// HandlePattern routes records of every topic matching pattern to processor.
+func (r *Router) HandlePattern(pattern *regexp.Regexp, processor Processor) {
+	r.patterns = append(r.patterns, patternRoute{pattern: pattern, processor: processor})
+}
+
+// ProcessRecord processes a Kafka record with the Processor routed to its topic.
+func (r *Router) ProcessRecord(ctx context.Context, record *kgo.Record) error {
+	topic := retrytopic.OriginalTopic(record)
+	if p := r.route(topic); p != nil {
+		return p.ProcessRecord(ctx, record)
+	}
+
+	switch r.unmatched {
+	case UnmatchedError:
+		return fmt.Errorf("%w %q", ErrNoRoute, topic)
+	case UnmatchedDLQ:
+		return retry.Permanent(fmt.Errorf("%w %q", ErrNoRoute, topic))
+	default:
+		return nil
+	}
+}
+
+func (r *Router) route(topic string) Processor {
+	if p, ok := r.routes[topic]; ok {
+		return p
+	}
+	for _, route := range r.patterns {
+		if route.pattern.MatchString(topic) {
+			return route.processor
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/proto"
+)
+
+// Decoder decodes record values into values of type T.
+type Decoder[T any] interface {
+	Decode(data []byte) (T, error)
+}
+
+// DecoderFunc adapts a function to the Decoder interface.
+type DecoderFunc[T any] func(data []byte) (T, error)
+
+// Decode calls f(data).
+func (f DecoderFunc[T]) Decode(data []byte) (T, error) {
+	return f(data)
+}
+
+// DecodeError is returned when a record value cannot be decoded.
+// It is reported with the "decode" error type in metrics and spans, and is permanent: decoding
+// the same value again fails the same way, so it is not retried but handed to failure handling.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode record: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorType classifies the error for telemetry.
+func (e *DecodeError) ErrorType() string {
+	return "decode"
+}
+
+// Permanent reports that the error is not worth retrying, see retry.IsPermanent.
+func (e *DecodeError) Permanent() bool {
+	return true
+}
+
+// TypedHandler handles a record together with its decoded value.
+type TypedHandler[T any] func(ctx context.Context, record *kgo.Record, value T) error
+
+type typedProcessor[T any] struct {
+	decoder Decoder[T]
+	handler TypedHandler[T]
+}
+
+// Typed returns a Processor that decodes every record value with decoder and passes the result
+// to handler. Decoding failures are returned as a *DecodeError without calling handler.
+func Typed[T any](decoder Decoder[T], handler TypedHandler[T]) Processor {
+	return &typedProcessor[T]{
+		decoder: decoder,
+		handler: handler,
+	}
+}
+
+func (p *typedProcessor[T]) ProcessRecord(ctx context.Context, record *kgo.Record) error {
+	value, err := p.decoder.Decode(record.Value)
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	return p.handler(ctx, record, value)
+}
+
+// JSON returns a Decoder that unmarshals JSON into T.
+func JSON[T any]() Decoder[T] {
+	return DecoderFunc[T](func(data []byte) (T, error) {
+		var value T
+		err := json.Unmarshal(data, &value)
+		return value, err
+	})
+}
+
+// Protobuf returns a Decoder that unmarshals protobuf into a new *T, e.g. Protobuf[pb.Order]().
+func Protobuf[T any, PT interface {
+	*T
+	proto.Message
+}]() Decoder[PT] {
+	return DecoderFunc[PT](func(data []byte) (PT, error) {
+		value := PT(new(T))
+		if err := proto.Unmarshal(data, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
+
+// Raw returns a Decoder that passes record values through unchanged.
+func Raw() Decoder[[]byte] {
+	return DecoderFunc[[]byte](func(data []byte) ([]byte, error) {
+		return data, nil
+	})
+}
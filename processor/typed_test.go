@@ -0,0 +1,33 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Jdemon/ktel/retry"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestTypedDecodeFailureIsPermanent(t *testing.T) {
+	type order struct {
+		ID string `json:"id"`
+	}
+
+	handled := 0
+	p := Typed(JSON[order](), func(context.Context, *kgo.Record, order) error {
+		handled++
+		return nil
+	})
+
+	attempts, err := retry.Policy{MaxAttempts: 5}.Do(context.Background(), func(int) error {
+		return p.ProcessRecord(context.Background(), &kgo.Record{Value: []byte("not json")})
+	})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("ProcessRecord() error = %v, want a *DecodeError", err)
+	}
+	if attempts != 1 || handled != 0 {
+		t.Errorf("decode failure made %d attempts and reached the handler %d times, want 1 and 0", attempts, handled)
+	}
+}
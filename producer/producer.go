@@ -0,0 +1,121 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client defines the Kafka client operation the Producer needs.
+type Client interface {
+	Produce(ctx context.Context, r *kgo.Record, promise func(*kgo.Record, error))
+}
+
+// Producer produces records with tracing and metrics. Every record gets a publish span, the W3C
+// trace context and baggage of that span in its headers, and the correlation id of the record
+// being processed, if any.
+type Producer struct {
+	client       Client
+	instrumentor *telemetry.Instrumentor
+	tracer       trace.Tracer
+}
+
+// New creates a new Producer. instrumentor may be nil to disable metrics.
+func New(client Client, instrumentor *telemetry.Instrumentor, tracer trace.Tracer) *Producer {
+	return &Producer{
+		client:       client,
+		instrumentor: instrumentor,
+		tracer:       tracer,
+	}
+}
+
+// Send produces rec and waits until it is acknowledged.
+func (p *Producer) Send(ctx context.Context, rec *kgo.Record) error {
+	return p.ProduceSync(ctx, rec).FirstErr()
+}
+
+// SendAsync produces rec without waiting for it. If promise is non-nil, it is called once rec
+// has been acknowledged or has failed.
+func (p *Producer) SendAsync(ctx context.Context, rec *kgo.Record, promise func(*kgo.Record, error)) {
+	p.produce(ctx, rec, func(r *kgo.Record, err error) {
+		if promise != nil {
+			promise(r, err)
+		}
+	})
+}
+
+// SendBatch produces records and waits until all of them are acknowledged. It returns the errors
+// of the records that failed, joined.
+func (p *Producer) SendBatch(ctx context.Context, records ...*kgo.Record) error {
+	var errs []error
+	for _, result := range p.ProduceSync(ctx, records...) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("topic %s: %w", result.Record.Topic, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ProduceSync produces records and waits until all of them are acknowledged, like
+// kgo.Client.ProduceSync. It makes the Producer a processor.Producer.
+func (p *Producer) ProduceSync(ctx context.Context, records ...*kgo.Record) kgo.ProduceResults {
+	results := make(kgo.ProduceResults, 0, len(records))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(records))
+	for _, rec := range records {
+		p.produce(ctx, rec, func(r *kgo.Record, err error) {
+			mu.Lock()
+			results = append(results, kgo.ProduceResult{Record: r, Err: err})
+			mu.Unlock()
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *Producer) produce(ctx context.Context, rec *kgo.Record, promise func(*kgo.Record, error)) {
+	startTime := time.Now()
+	spanName := fmt.Sprintf("%s publish", rec.Topic)
+	ctx, span := p.tracer.Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.kafka.topic", rec.Topic)),
+	)
+
+	if telemetry.CorrelationID(rec) == "" {
+		if id := telemetry.CorrelationIDFromContext(ctx); id != "" {
+			rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: telemetry.HeaderCorrelationID, Value: []byte(id)})
+		}
+	}
+	if id := telemetry.CorrelationID(rec); id != "" {
+		span.SetAttributes(attribute.String("messaging.message.conversation_id", id))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, telemetry.RecordCarrier{Record: rec})
+
+	p.client.Produce(ctx, rec, func(r *kgo.Record, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(
+				attribute.Int("messaging.kafka.partition", int(r.Partition)),
+				attribute.Int64("messaging.kafka.offset", r.Offset),
+			)
+		}
+		span.End()
+		if p.instrumentor != nil {
+			p.instrumentor.InstrumentProduce(ctx, r, err, startTime)
+		}
+		promise(r, err)
+	})
+}
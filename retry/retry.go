@@ -0,0 +1,89 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy describes how a failed operation is retried with exponential backoff.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one. Values below 1 mean a single attempt.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every retry. Values below 1 keep the delay constant.
+	Multiplier float64
+	// Jitter randomizes every delay by up to this fraction of it, in either direction.
+	Jitter float64
+}
+
+// Backoff returns the delay to wait after the given failed attempt, counting from 1.
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := math.Max(p.Multiplier, 1)
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		backoff = math.Min(backoff, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// Do calls fn until it succeeds, the attempts are exhausted or it returns a permanent error,
+// waiting between attempts as the policy dictates. Waiting stops early when ctx is done, in which case the context error
+// is returned. Do returns the number of attempts made together with the last error.
+func (p Policy) Do(ctx context.Context, fn func(attempt int) error) (int, error) {
+	maxAttempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(attempt); err == nil || attempt >= maxAttempts || IsPermanent(err) {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying. Failure handling, such as dead-lettering, still applies.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error in its chain, was marked with Permanent or has
+// a Permanent method that returns true, such as a record that cannot be decoded.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	var classified interface{ Permanent() bool }
+	return errors.As(err, &classified) && classified.Permanent()
+}
@@ -0,0 +1,132 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first retry", policy: Policy{InitialBackoff: time.Second, Multiplier: 2}, attempt: 1, want: time.Second},
+		{name: "grows exponentially", policy: Policy{InitialBackoff: time.Second, Multiplier: 2}, attempt: 4, want: 8 * time.Second},
+		{name: "capped", policy: Policy{InitialBackoff: time.Second, Multiplier: 2, MaxBackoff: 5 * time.Second}, attempt: 4, want: 5 * time.Second},
+		{name: "multiplier below one keeps the delay", policy: Policy{InitialBackoff: time.Second, Multiplier: 0.5}, attempt: 3, want: time.Second},
+		{name: "no backoff", policy: Policy{}, attempt: 3, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyBackoffJitter(t *testing.T) {
+	policy := Policy{InitialBackoff: time.Second, Jitter: 0.5}
+	for range 100 {
+		if got := policy.Backoff(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("Backoff(1) = %v, want within 50%% of 1s", got)
+		}
+	}
+}
+
+func TestPolicyDo(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		errs        []error // returned by successive attempts, nil once exhausted
+		attempts    int
+		wantErr     error
+	}{
+		{name: "succeeds at once", maxAttempts: 3, attempts: 1},
+		{name: "succeeds after retries", maxAttempts: 3, errs: []error{errFailed, errFailed}, attempts: 3},
+		{name: "attempts exhausted", maxAttempts: 3, errs: []error{errFailed, errFailed, errFailed, errFailed}, attempts: 3, wantErr: errFailed},
+		{name: "single attempt by default", errs: []error{errFailed}, attempts: 1, wantErr: errFailed},
+		{name: "permanent error stops retries", maxAttempts: 5, errs: []error{errFailed, Permanent(errFailed)}, attempts: 2, wantErr: errFailed},
+		{name: "wrapped permanent error", maxAttempts: 5, errs: []error{fmt.Errorf("decode: %w", Permanent(errFailed))}, attempts: 1, wantErr: errFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := Policy{MaxAttempts: tt.maxAttempts}
+			attempts, err := policy.Do(context.Background(), func(attempt int) error {
+				if attempt > len(tt.errs) {
+					return nil
+				}
+				return tt.errs[attempt-1]
+			})
+			if attempts != tt.attempts {
+				t.Errorf("Do() attempts = %d, want %d", attempts, tt.attempts)
+			}
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyDoStopsWaitingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Hour}
+	attempts, err := policy.Do(ctx, func(int) error { return errors.New("failed") })
+	if attempts != 1 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %d, %v, want 1, context.Canceled", attempts, err)
+	}
+}
+
+// classifiedError reports itself as permanent or not.
+type classifiedError bool
+
+func (e classifiedError) Error() string   { return "classified" }
+func (e classifiedError) Permanent() bool { return bool(e) }
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errors.New("failed"), want: false},
+		{name: "marked", err: Permanent(errors.New("failed")), want: true},
+		{name: "wrapped mark", err: fmt.Errorf("handler: %w", Permanent(errors.New("failed"))), want: true},
+		{name: "permanent method", err: classifiedError(true), want: true},
+		{name: "wrapped permanent method", err: fmt.Errorf("decode: %w", classifiedError(true)), want: true},
+		{name: "transient method", err: classifiedError(false), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+	err := errors.New("failed")
+	if IsPermanent(err) {
+		t.Error("IsPermanent() of a plain error = true")
+	}
+	if !IsPermanent(Permanent(err)) || !errors.Is(Permanent(err), err) {
+		t.Error("Permanent() does not mark and wrap the error")
+	}
+}
@@ -0,0 +1,218 @@
+package retrytopic
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Jdemon/ktel/dlq"
+	"github.com/Jdemon/ktel/retry"
+	"github.com/Jdemon/ktel/telemetry"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers added to records routed to a retry topic. The original headers describe where the
+// record was first consumed from and are set once, when it enters the first tier.
+const (
+	// HeaderNotBefore holds the Unix time in milliseconds before which the record must not be processed.
+	HeaderNotBefore         = "ktel.retry.not-before"
+	HeaderOriginalTopic     = "ktel.retry.original.topic"
+	HeaderOriginalPartition = "ktel.retry.original.partition"
+	HeaderOriginalOffset    = "ktel.retry.original.offset"
+	HeaderOriginalTimestamp = "ktel.retry.original.timestamp"
+	HeaderError             = "ktel.retry.error"
+	// HeaderAttempts holds the number of attempts made in every tier so far.
+	HeaderAttempts = "ktel.retry.attempts"
+)
+
+// Tier is a retry topic together with the delay its records wait before being processed again.
+type Tier struct {
+	Topic string
+	Delay time.Duration
+}
+
+// Tiers derives the retry topics of a consumer group from the topic it consumes, one per delay,
+// named "<topic>.<group>.retry.<delay>", e.g. "orders.billing.retry.1m". Without a topic, the
+// names are "<group>.retry.<delay>".
+func Tiers(topic, group string, delays []time.Duration) []Tier {
+	prefix := group
+	if topic != "" {
+		prefix = topic + "." + group
+	}
+
+	tiers := make([]Tier, len(delays))
+	for i, delay := range delays {
+		tiers[i] = Tier{
+			Topic: fmt.Sprintf("%s.retry.%s", prefix, formatDelay(delay)),
+			Delay: delay,
+		}
+	}
+	return tiers
+}
+
+// Topics returns the topic names of the given tiers.
+func Topics(tiers []Tier) []string {
+	topics := make([]string, len(tiers))
+	for i, tier := range tiers {
+		topics[i] = tier.Topic
+	}
+	return topics
+}
+
+func formatDelay(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	default:
+		return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+	}
+}
+
+// NotBefore returns the time before which rec must not be processed, if it carries one.
+func NotBefore(rec *kgo.Record) (time.Time, bool) {
+	for _, h := range rec.Headers {
+		if h.Key != HeaderNotBefore {
+			continue
+		}
+		millis, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.UnixMilli(millis), true
+	}
+	return time.Time{}, false
+}
+
+// OriginalTopic returns the topic rec was first consumed from, which differs from rec.Topic
+// when rec was consumed from a retry topic.
+func OriginalTopic(rec *kgo.Record) string {
+	for _, h := range rec.Headers {
+		if h.Key == HeaderOriginalTopic {
+			return string(h.Value)
+		}
+	}
+	return rec.Topic
+}
+
+// original returns rec as it was first consumed, with the topic, partition, offset and timestamp
+// carried in its retry headers. Records that were not consumed from a retry topic are returned
+// as they are.
+func original(rec *kgo.Record) *kgo.Record {
+	if rec.Topic == OriginalTopic(rec) {
+		return rec
+	}
+	orig := *rec
+	orig.Topic = OriginalTopic(rec)
+	for _, h := range rec.Headers {
+		switch h.Key {
+		case HeaderOriginalPartition:
+			if partition, err := strconv.ParseInt(string(h.Value), 10, 32); err == nil {
+				orig.Partition = int32(partition)
+			}
+		case HeaderOriginalOffset:
+			if offset, err := strconv.ParseInt(string(h.Value), 10, 64); err == nil {
+				orig.Offset = offset
+			}
+		case HeaderOriginalTimestamp:
+			if millis, err := strconv.ParseInt(string(h.Value), 10, 64); err == nil {
+				orig.Timestamp = time.UnixMilli(millis)
+			}
+		}
+	}
+	return &orig
+}
+
+// priorAttempts returns the number of attempts rec went through in earlier tiers.
+func priorAttempts(rec *kgo.Record) int {
+	for _, h := range rec.Headers {
+		if h.Key == HeaderAttempts {
+			attempts, _ := strconv.Atoi(string(h.Value))
+			return attempts
+		}
+	}
+	return 0
+}
+
+// Router routes failed records to the next retry tier without blocking their partition.
+// Records failing in the last tier are published to the dead-letter topic.
+type Router struct {
+	producer     dlq.Producer
+	tiers        []Tier
+	deadLetter   *dlq.Publisher
+	instrumentor *telemetry.Instrumentor
+}
+
+// NewRouter creates a new Router.
+func NewRouter(producer dlq.Producer, tiers []Tier, deadLetter *dlq.Publisher, instrumentor *telemetry.Instrumentor) *Router {
+	return &Router{
+		producer:     producer,
+		tiers:        tiers,
+		deadLetter:   deadLetter,
+		instrumentor: instrumentor,
+	}
+}
+
+// HandleFailure produces rec to the retry tier following the one it was consumed from, due
+// after that tier's delay, or to the dead-letter topic once every tier has been tried.
+// Permanent errors go to the dead-letter topic straight away. Dead-lettered records are described
+// by where they were first consumed from and by the attempts made in all tiers.
+func (r *Router) HandleFailure(ctx context.Context, rec *kgo.Record, cause error, attempts int) error {
+	attempts += priorAttempts(rec)
+	next := r.nextTier(rec.Topic)
+	if next == len(r.tiers) || retry.IsPermanent(cause) {
+		return r.deadLetter.HandleFailure(ctx, original(rec), cause, attempts)
+	}
+	tier := r.tiers[next]
+
+	retryRecord := &kgo.Record{
+		Topic:   tier.Topic,
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: r.headers(rec, cause, attempts, time.Now().Add(tier.Delay)),
+	}
+	err := r.producer.ProduceSync(ctx, retryRecord).FirstErr()
+	if r.instrumentor != nil {
+		r.instrumentor.InstrumentRetryTopic(ctx, rec, tier.Topic, err == nil)
+	}
+	return err
+}
+
+// nextTier returns the index of the tier after the one topic belongs to. Records from any
+// other topic start at the first tier.
+func (r *Router) nextTier(topic string) int {
+	for i, tier := range r.tiers {
+		if tier.Topic == topic {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (r *Router) headers(rec *kgo.Record, cause error, attempts int, notBefore time.Time) []kgo.RecordHeader {
+	headers := make([]kgo.RecordHeader, 0, len(rec.Headers)+7)
+	for _, h := range rec.Headers {
+		switch h.Key {
+		case HeaderNotBefore, HeaderError, HeaderAttempts:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	if rec.Topic == OriginalTopic(rec) {
+		headers = append(headers,
+			kgo.RecordHeader{Key: HeaderOriginalTopic, Value: []byte(rec.Topic)},
+			kgo.RecordHeader{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(rec.Partition)))},
+			kgo.RecordHeader{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(rec.Offset, 10))},
+			kgo.RecordHeader{Key: HeaderOriginalTimestamp, Value: []byte(strconv.FormatInt(rec.Timestamp.UnixMilli(), 10))},
+		)
+	}
+	return append(headers,
+		kgo.RecordHeader{Key: HeaderNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(cause.Error())},
+		kgo.RecordHeader{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+}
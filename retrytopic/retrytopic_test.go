@@ -0,0 +1,103 @@
+package retrytopic
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jdemon/ktel/dlq"
+	"github.com/Jdemon/ktel/retry"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeProducer records what it produces.
+type fakeProducer struct {
+	produced []*kgo.Record
+}
+
+func (p *fakeProducer) ProduceSync(_ context.Context, rs ...*kgo.Record) kgo.ProduceResults {
+	results := make(kgo.ProduceResults, len(rs))
+	for i, r := range rs {
+		p.produced = append(p.produced, r)
+		results[i] = kgo.ProduceResult{Record: r}
+	}
+	return results
+}
+
+func header(rec *kgo.Record, key string) string {
+	for _, h := range rec.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestTiers(t *testing.T) {
+	tiers := Tiers("orders", "billing", []time.Duration{30 * time.Second, time.Minute, 2 * time.Hour, 1500 * time.Millisecond})
+	want := []string{"orders.billing.retry.30s", "orders.billing.retry.1m", "orders.billing.retry.2h", "orders.billing.retry.1500ms"}
+	if got := Topics(tiers); !reflect.DeepEqual(got, want) {
+		t.Errorf("Topics(Tiers()) = %v, want %v", got, want)
+	}
+	if got := Topics(Tiers("", "billing", []time.Duration{time.Minute})); !reflect.DeepEqual(got, []string{"billing.retry.1m"}) {
+		t.Errorf("Topics(Tiers()) without topic = %v", got)
+	}
+}
+
+func TestRouterCarriesOriginThroughTiers(t *testing.T) {
+	producer := &fakeProducer{}
+	tiers := Tiers("orders", "billing", []time.Duration{time.Second, time.Minute})
+	router := NewRouter(producer, tiers, dlq.NewPublisher(producer, "orders.dlq", "billing", nil), nil)
+	ctx := context.Background()
+	cause := errors.New("failed")
+	timestamp := time.UnixMilli(1700000000000)
+
+	// Every record produced by the router is consumed again from its topic, at a new position.
+	rec := &kgo.Record{Topic: "orders", Partition: 3, Offset: 42, Timestamp: timestamp, Key: []byte("k")}
+	for i, attempts := range []int{2, 3, 1} {
+		if err := router.HandleFailure(ctx, rec, cause, attempts); err != nil {
+			t.Fatalf("HandleFailure() error = %v", err)
+		}
+		produced := producer.produced[i]
+		rec = &kgo.Record{Topic: produced.Topic, Partition: 0, Offset: int64(100 + i), Timestamp: time.Now(), Key: produced.Key, Headers: produced.Headers}
+	}
+
+	if got := producer.produced[1].Topic; got != tiers[1].Topic {
+		t.Errorf("second retry produced to %q, want %q", got, tiers[1].Topic)
+	}
+	if got := header(producer.produced[1], HeaderAttempts); got != "5" {
+		t.Errorf("second retry carries %s attempts, want 5", got)
+	}
+
+	deadLettered := producer.produced[2]
+	if deadLettered.Topic != "orders.dlq" {
+		t.Fatalf("last failure produced to %q, want the dead-letter topic", deadLettered.Topic)
+	}
+	for key, want := range map[string]string{
+		dlq.HeaderOriginalTopic:     "orders",
+		dlq.HeaderOriginalPartition: "3",
+		dlq.HeaderOriginalOffset:    "42",
+		dlq.HeaderOriginalTimestamp: timestamp.UTC().Format(time.RFC3339Nano),
+		dlq.HeaderAttempts:          "6",
+	} {
+		if got := header(deadLettered, key); got != want {
+			t.Errorf("dead-lettered header %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestRouterDeadLettersPermanentErrors(t *testing.T) {
+	producer := &fakeProducer{}
+	tiers := Tiers("orders", "billing", []time.Duration{time.Second})
+	router := NewRouter(producer, tiers, dlq.NewPublisher(producer, "orders.dlq", "billing", nil), nil)
+
+	rec := &kgo.Record{Topic: "orders", Partition: 1, Offset: 7}
+	if err := router.HandleFailure(context.Background(), rec, retry.Permanent(errors.New("bad payload")), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := producer.produced[0]; got.Topic != "orders.dlq" || header(got, dlq.HeaderOriginalOffset) != "7" {
+		t.Errorf("permanent error produced to %q with offset %q, want the dead-letter topic and 7", got.Topic, header(got, dlq.HeaderOriginalOffset))
+	}
+}
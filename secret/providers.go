@@ -0,0 +1,136 @@
+package secret
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// File resolves references to the contents of the file at the referenced path, without
+// trailing newlines, as mounted by Kubernetes or Docker secrets.
+type File struct{}
+
+// Secret reads the file at path.
+func (File) Secret(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Env resolves references to the value of the referenced environment variable.
+type Env struct{}
+
+// Secret returns the value of the environment variable name, which must be set.
+func (Env) Secret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// EncryptedFile resolves references to the values of a local file holding a JSON object of
+// named secrets, encrypted with AES-256-GCM as written by Seal. The file and its key are read on
+// every call, so both can be replaced while the application runs.
+type EncryptedFile struct {
+	// Path is the encrypted file.
+	Path string
+	// KeyFile holds the base64-encoded 32-byte key.
+	KeyFile string
+}
+
+// Secret decrypts the file and returns the secret called name.
+func (f EncryptedFile) Secret(_ context.Context, name string) (string, error) {
+	key, err := readKey(f.KeyFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encrypted secrets file: %w", err)
+	}
+	secrets, err := Open(data, key)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s in %s", ErrNotFound, name, f.Path)
+	}
+	return secret, nil
+}
+
+func readKey(path string) ([]byte, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secrets key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts secrets with the 32-byte key into the format read by EncryptedFile: a random
+// nonce followed by the AES-256-GCM ciphertext of the secrets as a JSON object.
+func Seal(secrets map[string]string, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode secrets: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts secrets sealed with key by Seal.
+func Open(data, key []byte) (map[string]string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("failed to decrypt secrets: data too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets: %w", err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to decode secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,135 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestSealOpen(t *testing.T) {
+	secrets := map[string]string{"kafka-password": "hunter2", "registry-password": "s3cret"}
+	sealed, err := Seal(secrets, testKey(1))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Contains(sealed, []byte("hunter2")) {
+		t.Error("Seal() output contains a secret in plain text")
+	}
+	resealed, err := Seal(secrets, testKey(1))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Equal(sealed, resealed) {
+		t.Error("Seal() reused its nonce")
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		data    []byte
+		key     []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "round trip", data: sealed, key: testKey(1), want: secrets},
+		{name: "tampered", data: tampered, key: testKey(1), wantErr: true},
+		{name: "wrong key", data: sealed, key: testKey(2), wantErr: true},
+		{name: "short key", data: sealed, key: testKey(1)[:16], wantErr: true},
+		{name: "truncated", data: sealed[:8], key: testKey(1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.data, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Open() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealRejectsShortKey(t *testing.T) {
+	if _, err := Seal(map[string]string{"a": "b"}, testKey(1)[:31]); err == nil {
+		t.Error("Seal() with a 31-byte key succeeded")
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "password")
+	if err := os.WriteFile(path, []byte("hunter2\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := (File{}).Secret(context.Background(), path); err != nil || got != "hunter2" {
+		t.Errorf("Secret() = %q, %v, want the contents without the trailing newline", got, err)
+	}
+	if _, err := (File{}).Secret(context.Background(), filepath.Join(dir, "missing")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Secret() of a missing file error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sealed, err := Seal(map[string]string{"kafka-password": "hunter2"}, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile("secrets.enc", sealed)
+	keyFile := writeFile("secrets.key", []byte(base64.StdEncoding.EncodeToString(testKey(1))+"\n"))
+	wrongKeyFile := writeFile("wrong.key", []byte(base64.StdEncoding.EncodeToString(testKey(2))))
+	badKeyFile := writeFile("bad.key", []byte("not base64!"))
+
+	tests := []struct {
+		name    string
+		file    EncryptedFile
+		secret  string
+		want    string
+		wantErr bool
+		errIs   error
+	}{
+		{name: "found", file: EncryptedFile{Path: path, KeyFile: keyFile}, secret: "kafka-password", want: "hunter2"},
+		{name: "missing secret", file: EncryptedFile{Path: path, KeyFile: keyFile}, secret: "other", wantErr: true, errIs: ErrNotFound},
+		{name: "wrong key", file: EncryptedFile{Path: path, KeyFile: wrongKeyFile}, secret: "kafka-password", wantErr: true},
+		{name: "undecodable key", file: EncryptedFile{Path: path, KeyFile: badKeyFile}, secret: "kafka-password", wantErr: true},
+		{name: "missing key file", file: EncryptedFile{Path: path, KeyFile: filepath.Join(dir, "missing.key")}, secret: "kafka-password", wantErr: true},
+		{name: "missing secrets file", file: EncryptedFile{Path: filepath.Join(dir, "missing.enc"), KeyFile: keyFile}, secret: "kafka-password", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.file.Secret(context.Background(), tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Secret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Errorf("Secret() error = %v, want %v", err, tt.errIs)
+			}
+			if got != tt.want {
+				t.Errorf("Secret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Package secret resolves secret references in the configuration, such as
+// "secret:file:/run/secrets/kafka-password", through pluggable providers.
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Prefix starts every secret reference. A reference has the form secret:<scheme>:<ref>, where
+// scheme selects the Provider and ref is passed to it.
+const Prefix = "secret:"
+
+// ErrNotFound is returned by providers when a referenced secret does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider resolves references of one scheme to secret values. Providers are asked again every
+// time a secret is needed, e.g. whenever the Kafka client authenticates a new connection, so
+// they pick up rotated secrets.
+type Provider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface.
+type ProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// Secret calls f(ctx, ref).
+func (f ProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"file": File{},
+		"env":  Env{},
+	}
+)
+
+// Register makes provider available under scheme, replacing any provider registered before.
+// The file and env schemes are registered by default. Providers must be registered before the
+// configuration is loaded.
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = provider
+}
+
+// Lookup returns the provider registered under scheme.
+func Lookup(scheme string) (Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	provider, ok := providers[scheme]
+	return provider, ok
+}
+
+// Parse splits the secret reference value into its scheme and ref. ok is false if value is not
+// a reference, i.e. a plain secret.
+func Parse(value string) (scheme, ref string, ok bool) {
+	rest, ok := strings.CutPrefix(value, Prefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// Resolve returns the secret value references, through the provider registered for its scheme.
+// Values that are not references are returned unchanged.
+func Resolve(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := Parse(value)
+	if !ok {
+		return value, nil
+	}
+	provider, ok := Lookup(scheme)
+	if !ok {
+		return "", fmt.Errorf("no secret provider registered for scheme %q", scheme)
+	}
+	return ResolveWith(ctx, provider, scheme, ref)
+}
+
+// ResolveWith resolves ref with provider, wrapping its error with the scheme.
+func ResolveWith(ctx context.Context, provider Provider, scheme, ref string) (string, error) {
+	secret, err := provider.Secret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret: %w", scheme, err)
+	}
+	return secret, nil
+}
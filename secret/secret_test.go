@@ -0,0 +1,94 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantScheme string
+		wantRef    string
+		wantOK     bool
+	}{
+		{name: "file", value: "secret:file:/run/secrets/kafka", wantScheme: "file", wantRef: "/run/secrets/kafka", wantOK: true},
+		{name: "ref with colons", value: "secret:vault:kv/data/kafka:password", wantScheme: "vault", wantRef: "kv/data/kafka:password", wantOK: true},
+		{name: "plain", value: "hunter2", wantOK: false},
+		{name: "prefix only", value: "secret:", wantOK: false},
+		{name: "empty", value: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, ref, ok := Parse(tt.value)
+			if scheme != tt.wantScheme || ref != tt.wantRef || ok != tt.wantOK {
+				t.Errorf("Parse(%q) = %q, %q, %v, want %q, %q, %v", tt.value, scheme, ref, ok, tt.wantScheme, tt.wantRef, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("KTEL_TEST_SECRET", "from-env")
+	errUnavailable := errors.New("unavailable")
+	Register("test", ProviderFunc(func(_ context.Context, ref string) (string, error) {
+		if ref == "down" {
+			return "", errUnavailable
+		}
+		return "test:" + ref, nil
+	}))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+		errIs   error
+	}{
+		{name: "plain value", value: "hunter2", want: "hunter2"},
+		{name: "env", value: "secret:env:KTEL_TEST_SECRET", want: "from-env"},
+		{name: "env unset", value: "secret:env:KTEL_TEST_UNSET", wantErr: true, errIs: ErrNotFound},
+		{name: "registered provider", value: "secret:test:kafka", want: "test:kafka"},
+		{name: "provider error", value: "secret:test:down", wantErr: true, errIs: errUnavailable},
+		{name: "unknown scheme", value: "secret:unknown:kafka", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Errorf("Resolve(%q) error = %v, want %v", tt.value, err, tt.errIs)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, scheme := range []string{"file", "env"} {
+		if _, ok := Lookup(scheme); !ok {
+			t.Errorf("Lookup(%q) found no default provider", scheme)
+		}
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Error(`Lookup("missing") found a provider`)
+	}
+
+	Register("replaced", ProviderFunc(func(context.Context, string) (string, error) { return "first", nil }))
+	Register("replaced", ProviderFunc(func(context.Context, string) (string, error) { return "second", nil }))
+	provider, ok := Lookup("replaced")
+	if !ok {
+		t.Fatal(`Lookup("replaced") found no provider`)
+	}
+	if got, _ := provider.Secret(context.Background(), ""); got != "second" {
+		t.Errorf("registered provider returned %q, want the last one registered", got)
+	}
+}
@@ -0,0 +1,81 @@
+package serde
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Jdemon/ktel/processor"
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Avro returns a processor.Decoder that decodes Confluent-framed Avro payloads into T, using
+// the writer schema registered under the payload's schema ID. Parsed schemas are cached by ID.
+func Avro[T any](registry *Registry) processor.Decoder[T] {
+	var schemas sync.Map // map[int]avro.Schema
+
+	return processor.DecoderFunc[T](func(data []byte) (T, error) {
+		var value T
+		id, body, err := ParseWireFormat(data)
+		if err != nil {
+			return value, err
+		}
+
+		schema, ok := schemas.Load(id)
+		if !ok {
+			registered, err := registry.SchemaByID(context.Background(), id)
+			if err != nil {
+				return value, err
+			}
+			if registered.SchemaType != SchemaTypeAvro {
+				return value, fmt.Errorf("schema %d is %s, not %s", id, registered.SchemaType, SchemaTypeAvro)
+			}
+			parsed, err := avro.Parse(registered.Schema)
+			if err != nil {
+				return value, fmt.Errorf("failed to parse Avro schema %d: %w", id, err)
+			}
+			schema, _ = schemas.LoadOrStore(id, parsed)
+		}
+
+		if err := avro.Unmarshal(schema.(avro.Schema), body, &value); err != nil {
+			return value, err
+		}
+		return value, nil
+	})
+}
+
+// Protobuf returns a processor.Decoder that decodes Confluent-framed protobuf payloads into a
+// new *T, e.g. Protobuf[pb.Order](registry). The registered schema must be a protobuf schema;
+// the body is unmarshaled with the generated type, so T must be the message the payload's
+// message indexes refer to.
+func Protobuf[T any, PT interface {
+	*T
+	proto.Message
+}](registry *Registry) processor.Decoder[PT] {
+	return processor.DecoderFunc[PT](func(data []byte) (PT, error) {
+		id, body, err := ParseWireFormat(data)
+		if err != nil {
+			return nil, err
+		}
+
+		registered, err := registry.SchemaByID(context.Background(), id)
+		if err != nil {
+			return nil, err
+		}
+		if registered.SchemaType != SchemaTypeProtobuf {
+			return nil, fmt.Errorf("schema %d is %s, not %s", id, registered.SchemaType, SchemaTypeProtobuf)
+		}
+
+		_, body, err = parseMessageIndexes(body)
+		if err != nil {
+			return nil, err
+		}
+
+		value := PT(new(T))
+		if err := proto.Unmarshal(body, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
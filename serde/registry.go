@@ -0,0 +1,162 @@
+package serde
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Schema types reported by the Schema Registry.
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+)
+
+// Schema is a schema registered in the Schema Registry.
+type Schema struct {
+	ID         int
+	SchemaType string
+	Schema     string
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
+
+// WithBasicAuth authenticates requests to the registry with HTTP basic auth.
+func WithBasicAuth(username, password string) RegistryOption {
+	return WithBasicAuthFunc(username, func(context.Context) (string, error) {
+		return password, nil
+	})
+}
+
+// WithBasicAuthFunc authenticates requests to the registry with HTTP basic auth, calling password
+// for every request so that a rotated password is picked up.
+func WithBasicAuthFunc(username string, password func(ctx context.Context) (string, error)) RegistryOption {
+	return func(r *Registry) {
+		r.username = username
+		r.password = password
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to reach the registry.
+func WithHTTPClient(client *http.Client) RegistryOption {
+	return func(r *Registry) {
+		r.httpClient = client
+	}
+}
+
+// WithTimeout bounds every request to the registry.
+func WithTimeout(timeout time.Duration) RegistryOption {
+	return func(r *Registry) {
+		if timeout > 0 {
+			r.timeout = timeout
+		}
+	}
+}
+
+// Registry is a client for the Confluent Schema Registry REST API.
+// Schemas are immutable once registered, so they are cached by ID for the lifetime of the client.
+type Registry struct {
+	url        string
+	username   string
+	password   func(ctx context.Context) (string, error)
+	httpClient *http.Client
+	timeout    time.Duration
+
+	mu      sync.RWMutex
+	schemas map[int]*Schema
+}
+
+// NewRegistry creates a new Registry client for the registry at url.
+func NewRegistry(url string, opts ...RegistryOption) *Registry {
+	r := &Registry{
+		url:        strings.TrimRight(url, "/"),
+		httpClient: http.DefaultClient,
+		timeout:    10 * time.Second,
+		schemas:    make(map[int]*Schema),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// SchemaByID returns the schema registered under id, fetching it from the registry on first use.
+func (r *Registry) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	r.mu.RLock()
+	schema, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := r.fetchSchema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+type schemaResponse struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+type errorResponse struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (r *Registry) fetchSchema(ctx context.Context, id int) (*Schema, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d", r.url, id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	if r.username != "" {
+		password, err := r.password(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve schema registry password: %w", err)
+		}
+		req.SetBasicAuth(r.username, password)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Message == "" {
+			return nil, fmt.Errorf("failed to fetch schema %d: unexpected status %s", id, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to fetch schema %d: %s (error code %d)", id, errResp.Message, errResp.ErrorCode)
+	}
+
+	var schemaResp schemaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&schemaResp); err != nil {
+		return nil, fmt.Errorf("failed to decode schema %d: %w", id, err)
+	}
+
+	// The registry omits the schema type for Avro, its original and default format.
+	schemaType := schemaResp.SchemaType
+	if schemaType == "" {
+		schemaType = SchemaTypeAvro
+	}
+	return &Schema{ID: id, SchemaType: schemaType, Schema: schemaResp.Schema}, nil
+}
@@ -0,0 +1,220 @@
+package serde
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+)
+
+const orderSchema = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"amount","type":"long"}]}`
+
+// fakeRegistry is an in-process stand-in for the Schema Registry that serves schemas by ID
+// and counts the requests it gets.
+type fakeRegistry struct {
+	schemas  map[int]string
+	requests atomic.Int32
+	username string
+	password string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	if f.username != "" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != f.username || pass != f.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error_code":401,"message":"Unauthorized"}`)
+			return
+		}
+	}
+
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/schemas/ids/%d", &id); err != nil || r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, ok := f.schemas[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error_code":40403,"message":"Schema %d not found"}`, id)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func newFakeRegistry(t *testing.T, f *fakeRegistry) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRegistrySchemaByID(t *testing.T) {
+	fake := &fakeRegistry{schemas: map[int]string{
+		1: fmt.Sprintf(`{"schema":%q}`, orderSchema),
+		2: `{"schemaType":"PROTOBUF","schema":"syntax = \"proto3\";"}`,
+	}}
+	server := newFakeRegistry(t, fake)
+	registry := NewRegistry(server.URL + "/")
+
+	tests := []struct {
+		id         int
+		schemaType string
+		schema     string
+	}{
+		{id: 1, schemaType: SchemaTypeAvro, schema: orderSchema},
+		{id: 2, schemaType: SchemaTypeProtobuf, schema: `syntax = "proto3";`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schemaType, func(t *testing.T) {
+			schema, err := registry.SchemaByID(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("SchemaByID(%d) error = %v", tt.id, err)
+			}
+			if schema.ID != tt.id || schema.SchemaType != tt.schemaType || schema.Schema != tt.schema {
+				t.Errorf("SchemaByID(%d) = %+v", tt.id, schema)
+			}
+		})
+	}
+}
+
+func TestRegistryCachesSchemas(t *testing.T) {
+	fake := &fakeRegistry{schemas: map[int]string{1: fmt.Sprintf(`{"schema":%q}`, orderSchema)}}
+	server := newFakeRegistry(t, fake)
+	registry := NewRegistry(server.URL)
+
+	for range 3 {
+		if _, err := registry.SchemaByID(context.Background(), 1); err != nil {
+			t.Fatalf("SchemaByID() error = %v", err)
+		}
+	}
+	if got := fake.requests.Load(); got != 1 {
+		t.Errorf("registry got %d requests, want 1", got)
+	}
+}
+
+func TestRegistryErrors(t *testing.T) {
+	fake := &fakeRegistry{schemas: map[int]string{1: `not json`}}
+	server := newFakeRegistry(t, fake)
+	registry := NewRegistry(server.URL)
+
+	tests := []struct {
+		name string
+		id   int
+		want string
+	}{
+		{name: "not found", id: 7, want: "Schema 7 not found (error code 40403)"},
+		{name: "malformed response", id: 1, want: "failed to decode schema 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := registry.SchemaByID(context.Background(), tt.id)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("SchemaByID(%d) error = %v, want it to contain %q", tt.id, err, tt.want)
+			}
+		})
+	}
+
+	// Failed lookups are not cached.
+	registry.SchemaByID(context.Background(), 7)
+	if got := fake.requests.Load(); got != 3 {
+		t.Errorf("registry got %d requests, want 3", got)
+	}
+}
+
+func TestRegistryBasicAuth(t *testing.T) {
+	fake := &fakeRegistry{
+		schemas:  map[int]string{1: fmt.Sprintf(`{"schema":%q}`, orderSchema)},
+		username: "user",
+		password: "secret",
+	}
+	server := newFakeRegistry(t, fake)
+
+	if _, err := NewRegistry(server.URL).SchemaByID(context.Background(), 1); err == nil {
+		t.Error("SchemaByID() without credentials succeeded")
+	}
+	if _, err := NewRegistry(server.URL, WithBasicAuth("user", "secret")).SchemaByID(context.Background(), 1); err != nil {
+		t.Errorf("SchemaByID() with credentials error = %v", err)
+	}
+}
+
+func TestRegistryBasicAuthFunc(t *testing.T) {
+	fake := &fakeRegistry{
+		schemas:  map[int]string{1: fmt.Sprintf(`{"schema":%q}`, orderSchema), 2: fmt.Sprintf(`{"schema":%q}`, orderSchema)},
+		username: "user",
+		password: "secret",
+	}
+	server := newFakeRegistry(t, fake)
+
+	var calls atomic.Int32
+	registry := NewRegistry(server.URL, WithBasicAuthFunc("user", func(context.Context) (string, error) {
+		calls.Add(1)
+		return fake.password, nil
+	}))
+	if _, err := registry.SchemaByID(context.Background(), 1); err != nil {
+		t.Fatalf("SchemaByID() error = %v", err)
+	}
+
+	// The password is rotated; the next request picks it up.
+	fake.password = "rotated"
+	if _, err := registry.SchemaByID(context.Background(), 2); err != nil {
+		t.Errorf("SchemaByID() after rotation error = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("password resolved %d times, want 2", got)
+	}
+
+	errResolve := errors.New("vault unavailable")
+	failing := NewRegistry(server.URL, WithBasicAuthFunc("user", func(context.Context) (string, error) {
+		return "", errResolve
+	}))
+	if _, err := failing.SchemaByID(context.Background(), 1); !errors.Is(err, errResolve) {
+		t.Errorf("SchemaByID() error = %v, want %v", err, errResolve)
+	}
+}
+
+func TestAvroDecoder(t *testing.T) {
+	type order struct {
+		ID     string `avro:"id"`
+		Amount int64  `avro:"amount"`
+	}
+
+	fake := &fakeRegistry{schemas: map[int]string{
+		3: fmt.Sprintf(`{"schema":%q}`, orderSchema),
+		4: `{"schemaType":"PROTOBUF","schema":""}`,
+	}}
+	server := newFakeRegistry(t, fake)
+	decode := Avro[order](NewRegistry(server.URL))
+
+	body, err := avro.Marshal(avro.MustParse(orderSchema), order{ID: "o-1", Amount: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := func(id uint32, body []byte) []byte {
+		return append(binary.BigEndian.AppendUint32([]byte{magicByte}, id), body...)
+	}
+
+	got, err := decode.Decode(frame(3, body))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got != (order{ID: "o-1", Amount: 42}) {
+		t.Errorf("Decode() = %+v", got)
+	}
+
+	if _, err := decode.Decode(frame(4, body)); err == nil {
+		t.Error("Decode() of a protobuf schema succeeded")
+	}
+	if _, err := decode.Decode(body); err == nil {
+		t.Error("Decode() of an unframed payload succeeded")
+	}
+}
@@ -0,0 +1,55 @@
+package serde
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// magicByte starts every payload framed in the Confluent wire format.
+const magicByte = 0
+
+// ErrInvalidWireFormat is returned for payloads that are not framed in the Confluent wire format.
+var ErrInvalidWireFormat = errors.New("invalid Confluent wire format")
+
+// ParseWireFormat splits a Confluent-framed payload, a magic byte followed by a big-endian
+// 4-byte schema ID and the serialized body, into the schema ID and the body.
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < 5 {
+		return 0, nil, fmt.Errorf("%w: payload of %d bytes is too short", ErrInvalidWireFormat, len(data))
+	}
+	if data[0] != magicByte {
+		return 0, nil, fmt.Errorf("%w: unknown magic byte %d", ErrInvalidWireFormat, data[0])
+	}
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
+
+// parseMessageIndexes strips the protobuf message indexes that follow the schema ID. They are
+// a zigzag varint count followed by as many zigzag varint indexes, where a single zero byte is
+// shorthand for the first message of the schema.
+func parseMessageIndexes(data []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return nil, nil, fmt.Errorf("%w: invalid protobuf message index count", ErrInvalidWireFormat)
+	}
+	data = data[n:]
+	if count == 0 {
+		return []int{0}, data, nil
+	}
+	// Every index takes at least a byte, so a larger count cannot be valid. Checking it first
+	// keeps a corrupt count from allocating an arbitrary amount of memory.
+	if count > int64(len(data)) {
+		return nil, nil, fmt.Errorf("%w: protobuf message index count %d exceeds the payload", ErrInvalidWireFormat, count)
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("%w: invalid protobuf message index", ErrInvalidWireFormat)
+		}
+		indexes[i] = int(index)
+		data = data[n:]
+	}
+	return indexes, data, nil
+}
@@ -0,0 +1,88 @@
+package serde
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseWireFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		id      int
+		body    []byte
+		wantErr bool
+	}{
+		{name: "framed", data: []byte{0, 0, 0, 1, 2, 'a', 'b'}, id: 258, body: []byte("ab")},
+		{name: "empty body", data: []byte{0, 0, 0, 0, 7}, id: 7, body: []byte{}},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "too short", data: []byte{0, 0, 0, 1}, wantErr: true},
+		{name: "unknown magic byte", data: []byte{1, 0, 0, 0, 1, 'a'}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, body, err := ParseWireFormat(tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidWireFormat) {
+					t.Fatalf("ParseWireFormat() error = %v, want ErrInvalidWireFormat", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWireFormat() error = %v", err)
+			}
+			if id != tt.id || string(body) != string(tt.body) {
+				t.Errorf("ParseWireFormat() = %d, %q, want %d, %q", id, body, tt.id, tt.body)
+			}
+		})
+	}
+}
+
+func TestParseMessageIndexes(t *testing.T) {
+	varints := func(values ...int64) []byte {
+		var data []byte
+		for _, v := range values {
+			data = binary.AppendVarint(data, v)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		indexes []int
+		body    []byte
+		wantErr bool
+	}{
+		{name: "first message shorthand", data: append([]byte{0}, "body"...), indexes: []int{0}, body: []byte("body")},
+		{name: "single index", data: append(varints(1, 2), "body"...), indexes: []int{2}, body: []byte("body")},
+		{name: "nested indexes", data: append(varints(3, 1, 0, 4), "body"...), indexes: []int{1, 0, 4}, body: []byte("body")},
+		{name: "no body", data: varints(1, 5), indexes: []int{5}, body: []byte{}},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "negative count", data: varints(-1, 0), wantErr: true},
+		{name: "truncated indexes", data: varints(3, 1, 2), wantErr: true},
+		{name: "truncated varint", data: []byte{0x02, 0x80}, wantErr: true},
+		{name: "count larger than payload", data: binary.AppendVarint(nil, 1<<40), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes, body, err := parseMessageIndexes(tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidWireFormat) {
+					t.Fatalf("parseMessageIndexes() error = %v, want ErrInvalidWireFormat", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMessageIndexes() error = %v", err)
+			}
+			if !reflect.DeepEqual(indexes, tt.indexes) || string(body) != string(tt.body) {
+				t.Errorf("parseMessageIndexes() = %v, %q, want %v, %q", indexes, body, tt.indexes, tt.body)
+			}
+		})
+	}
+}
@@ -2,11 +2,14 @@ package telemetry
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -17,8 +20,25 @@ const (
 
 // Instrumentor holds the OpenTelemetry instruments and provides methods for common instrumentation.
 type Instrumentor struct {
-	MessagesProcessedCounter metric.Int64Counter
-	ProcessingTimeHistogram  metric.Float64Histogram
+	MessagesProcessedCounter    metric.Int64Counter
+	ProcessingTimeHistogram     metric.Float64Histogram
+	LanePendingCounter          metric.Int64UpDownCounter
+	LaneRecordsCounter          metric.Int64Counter
+	LaneTimeHistogram           metric.Float64Histogram
+	InFlightRecordsCounter      metric.Int64UpDownCounter
+	InFlightBytesCounter        metric.Int64UpDownCounter
+	PausedPartitionsCounter     metric.Int64UpDownCounter
+	DeadLetteredCounter         metric.Int64Counter
+	RetryTopicCounter           metric.Int64Counter
+	BatchSizeHistogram          metric.Int64Histogram
+	BatchTimeHistogram          metric.Float64Histogram
+	PanicsCounter               metric.Int64Counter
+	ProducedCounter             metric.Int64Counter
+	ProduceTimeHistogram        metric.Float64Histogram
+	DuplicatesCounter           metric.Int64Counter
+	RetryableFetchErrorsCounter metric.Int64Counter
+	FatalFetchErrorsCounter     metric.Int64Counter
+	ReplayRemainingGauge        metric.Int64Gauge
 }
 
 // NewInstrumentor creates and initializes the OpenTelemetry instruments.
@@ -42,18 +62,194 @@ func NewInstrumentor() (*Instrumentor, error) {
 		return nil, err
 	}
 
+	lanePendingCounter, err := meter.Int64UpDownCounter(
+		"kafka.consumer.lane.pending",
+		metric.WithDescription("The number of records queued or in progress on a consumer lane"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	laneRecordsCounter, err := meter.Int64Counter(
+		"kafka.consumer.lane.records",
+		metric.WithDescription("The number of records completed by a consumer lane"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	laneTimeHistogram, err := meter.Float64Histogram(
+		"kafka.consumer.lane.duration",
+		metric.WithDescription("The time a consumer lane spends on a record"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlightRecordsCounter, err := meter.Int64UpDownCounter(
+		"kafka.consumer.inflight.records",
+		metric.WithDescription("The number of records currently being processed"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlightBytesCounter, err := meter.Int64UpDownCounter(
+		"kafka.consumer.inflight.bytes",
+		metric.WithDescription("The size of the records currently being processed"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pausedPartitionsCounter, err := meter.Int64UpDownCounter(
+		"kafka.consumer.partitions.paused",
+		metric.WithDescription("The number of partitions paused because too many of their records are in flight"),
+		metric.WithUnit("{partition}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetteredCounter, err := meter.Int64Counter(
+		"kafka.messages.deadlettered",
+		metric.WithDescription("The number of Kafka messages published to a dead-letter topic"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retryTopicCounter, err := meter.Int64Counter(
+		"kafka.messages.retry.routed",
+		metric.WithDescription("The number of Kafka messages routed to a retry topic"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSizeHistogram, err := meter.Int64Histogram(
+		"kafka.batch.size",
+		metric.WithDescription("The number of Kafka messages per processed batch"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	batchTimeHistogram, err := meter.Float64Histogram(
+		"kafka.batch.processing.duration",
+		metric.WithDescription("The latency of processing batches of Kafka messages"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	panicsCounter, err := meter.Int64Counter(
+		"kafka.messages.panics",
+		metric.WithDescription("The number of panics recovered while processing Kafka messages"),
+		metric.WithUnit("{panic}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	producedCounter, err := meter.Int64Counter(
+		"kafka.messages.produced",
+		metric.WithDescription("The number of Kafka messages produced"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	produceTimeHistogram, err := meter.Float64Histogram(
+		"kafka.message.produce.duration",
+		metric.WithDescription("The latency of producing Kafka messages, until they are acknowledged"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicatesCounter, err := meter.Int64Counter(
+		"kafka.messages.duplicates.skipped",
+		metric.WithDescription("The number of duplicate Kafka messages skipped"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retryableFetchErrors, err := meter.Int64Counter(
+		"kafka.consumer.fetch.errors.retryable",
+		metric.WithDescription("The number of fetch errors the Kafka client recovers from by retrying"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fatalFetchErrors, err := meter.Int64Counter(
+		"kafka.consumer.fetch.errors.fatal",
+		metric.WithDescription("The number of fetch errors that stop the Kafka consumer"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	replayRemainingGauge, err := meter.Int64Gauge(
+		"kafka.replay.remaining",
+		metric.WithDescription("The number of offsets a bounded consumer has yet to consume from a partition"),
+		metric.WithUnit("{offset}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Instrumentor{
-		MessagesProcessedCounter: messagesProcessedCounter,
-		ProcessingTimeHistogram:  processingTimeHistogram,
+		MessagesProcessedCounter:    messagesProcessedCounter,
+		ProcessingTimeHistogram:     processingTimeHistogram,
+		LanePendingCounter:          lanePendingCounter,
+		LaneRecordsCounter:          laneRecordsCounter,
+		LaneTimeHistogram:           laneTimeHistogram,
+		InFlightRecordsCounter:      inFlightRecordsCounter,
+		InFlightBytesCounter:        inFlightBytesCounter,
+		PausedPartitionsCounter:     pausedPartitionsCounter,
+		DeadLetteredCounter:         deadLetteredCounter,
+		RetryTopicCounter:           retryTopicCounter,
+		BatchSizeHistogram:          batchSizeHistogram,
+		BatchTimeHistogram:          batchTimeHistogram,
+		PanicsCounter:               panicsCounter,
+		ProducedCounter:             producedCounter,
+		ProduceTimeHistogram:        produceTimeHistogram,
+		DuplicatesCounter:           duplicatesCounter,
+		RetryableFetchErrorsCounter: retryableFetchErrors,
+		FatalFetchErrorsCounter:     fatalFetchErrors,
+		ReplayRemainingGauge:        replayRemainingGauge,
 	}, nil
 }
 
 // InstrumentMessage instruments a message processing operation with metrics and trace attributes.
-func (i *Instrumentor) InstrumentMessage(ctx context.Context, record *kgo.Record, success bool, startTime time.Time) {
+// A non-nil err is recorded on the span and classified by ErrorType.
+func (i *Instrumentor) InstrumentMessage(ctx context.Context, record *kgo.Record, err error, startTime time.Time) {
 	duration := float64(time.Since(startTime).Microseconds()) / 1000.0
+	attempt := AttemptFromContext(ctx)
+	errorType := ErrorType(err)
 	metricAttrs := attribute.NewSet(
 		attribute.String("messaging.kafka.topic", record.Topic),
-		attribute.Bool("success", success),
+		attribute.Bool("success", err == nil),
+		attribute.Int("attempt", attempt),
+		attribute.String("error.type", errorType),
+		attribute.String("event.type", EventTypeFromContext(ctx)),
 	)
 	i.MessagesProcessedCounter.Add(ctx, 1, metric.WithAttributeSet(metricAttrs))
 	i.ProcessingTimeHistogram.Record(ctx, duration, metric.WithAttributeSet(metricAttrs))
@@ -62,8 +258,221 @@ func (i *Instrumentor) InstrumentMessage(ctx context.Context, record *kgo.Record
 	attrs := []attribute.KeyValue{
 		attribute.String("messaging.kafka.topic", record.Topic),
 		attribute.Int("messaging.kafka.partition", int(record.Partition)),
+		attribute.Int("messaging.kafka.attempt", attempt),
+	}
+	if id := CorrelationID(record); id != "" {
+		attrs = append(attrs, attribute.String("messaging.message.conversation_id", id))
 	}
 	span.SetAttributes(attrs...)
+	if err != nil {
+		span.SetAttributes(attribute.String("error.type", errorType))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// ErrorType classifies err for metrics and spans. Errors implementing ErrorType() string in
+// their chain report their own class, other errors are "processing", and nil is "".
+func ErrorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	var typed interface{ ErrorType() string }
+	if errors.As(err, &typed) {
+		return typed.ErrorType()
+	}
+	return "processing"
+}
+
+// InstrumentLaneSubmit records a record being queued on a consumer lane.
+func (i *Instrumentor) InstrumentLaneSubmit(ctx context.Context, lane int) {
+	i.LanePendingCounter.Add(ctx, 1, metric.WithAttributes(attribute.Int("lane", lane)))
+}
+
+// InstrumentLaneComplete records a consumer lane finishing a record.
+func (i *Instrumentor) InstrumentLaneComplete(ctx context.Context, lane int, startTime time.Time) {
+	duration := float64(time.Since(startTime).Microseconds()) / 1000.0
+	metricAttrs := attribute.NewSet(attribute.Int("lane", lane))
+	i.LanePendingCounter.Add(ctx, -1, metric.WithAttributeSet(metricAttrs))
+	i.LaneRecordsCounter.Add(ctx, 1, metric.WithAttributeSet(metricAttrs))
+	i.LaneTimeHistogram.Record(ctx, duration, metric.WithAttributeSet(metricAttrs))
+}
+
+// InstrumentInFlight adjusts the number and size of records currently being processed.
+func (i *Instrumentor) InstrumentInFlight(ctx context.Context, records, bytes int64) {
+	i.InFlightRecordsCounter.Add(ctx, records)
+	i.InFlightBytesCounter.Add(ctx, bytes)
+}
+
+// InstrumentPartitionPause records a partition being paused or resumed.
+func (i *Instrumentor) InstrumentPartitionPause(ctx context.Context, topic string, partition int32, paused bool) {
+	delta := int64(1)
+	if !paused {
+		delta = -1
+	}
+	i.PausedPartitionsCounter.Add(ctx, delta, metric.WithAttributes(
+		attribute.String("messaging.kafka.topic", topic),
+		attribute.Int("messaging.kafka.partition", int(partition)),
+	))
+}
+
+// InstrumentDeadLetter records a message being published to a dead-letter topic.
+func (i *Instrumentor) InstrumentDeadLetter(ctx context.Context, record *kgo.Record, dlqTopic string, success bool) {
+	i.DeadLetteredCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("messaging.kafka.topic", record.Topic),
+		attribute.String("messaging.kafka.dlq.topic", dlqTopic),
+		attribute.Bool("success", success),
+	))
+}
+
+// InstrumentRetryTopic records a message being routed to a retry topic.
+func (i *Instrumentor) InstrumentRetryTopic(ctx context.Context, record *kgo.Record, retryTopic string, success bool) {
+	i.RetryTopicCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("messaging.kafka.topic", record.Topic),
+		attribute.String("messaging.kafka.retry.topic", retryTopic),
+		attribute.Bool("success", success),
+	))
+}
+
+// InstrumentBatch instruments a batch processing operation with metrics.
+func (i *Instrumentor) InstrumentBatch(ctx context.Context, size, failed int, startTime time.Time) {
+	duration := float64(time.Since(startTime).Microseconds()) / 1000.0
+	metricAttrs := attribute.NewSet(attribute.Bool("success", failed == 0))
+	i.BatchSizeHistogram.Record(ctx, int64(size), metric.WithAttributeSet(metricAttrs))
+	i.BatchTimeHistogram.Record(ctx, duration, metric.WithAttributeSet(metricAttrs))
+}
+
+// InstrumentPanic records a panic recovered while processing a message on the span in ctx and in metrics.
+func (i *Instrumentor) InstrumentPanic(ctx context.Context, record *kgo.Record, err error) {
+	i.PanicsCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("messaging.kafka.topic", record.Topic)))
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// InstrumentProduce instruments a produce operation with metrics.
+func (i *Instrumentor) InstrumentProduce(ctx context.Context, record *kgo.Record, err error, startTime time.Time) {
+	duration := float64(time.Since(startTime).Microseconds()) / 1000.0
+	metricAttrs := attribute.NewSet(
+		attribute.String("messaging.kafka.topic", record.Topic),
+		attribute.Bool("success", err == nil),
+	)
+	i.ProducedCounter.Add(ctx, 1, metric.WithAttributeSet(metricAttrs))
+	i.ProduceTimeHistogram.Record(ctx, duration, metric.WithAttributeSet(metricAttrs))
+}
+
+// InstrumentDuplicate records a duplicate message being skipped.
+func (i *Instrumentor) InstrumentDuplicate(ctx context.Context, record *kgo.Record) {
+	i.DuplicatesCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("messaging.kafka.topic", record.Topic)))
+}
+
+// InstrumentFetchError records a fetch error, counted separately for retryable and fatal errors.
+func (i *Instrumentor) InstrumentFetchError(ctx context.Context, topic string, partition int32, fatal bool) {
+	counter := i.RetryableFetchErrorsCounter
+	if fatal {
+		counter = i.FatalFetchErrorsCounter
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("messaging.kafka.topic", topic),
+		attribute.Int("messaging.kafka.partition", int(partition)),
+	))
+}
+
+// InstrumentReplayProgress records the offsets a bounded consumer has yet to consume from a partition.
+func (i *Instrumentor) InstrumentReplayProgress(ctx context.Context, topic string, partition int32, remaining int64) {
+	i.ReplayRemainingGauge.Record(ctx, remaining, metric.WithAttributes(
+		attribute.String("messaging.kafka.topic", topic),
+		attribute.Int("messaging.kafka.partition", int(partition)),
+	))
+}
+
+type attemptKey struct{}
+
+// ContextWithAttempt returns a copy of ctx carrying the processing attempt of a record, counting from 1.
+func ContextWithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFromContext returns the processing attempt carried by ctx, or 1 if there is none.
+func AttemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// recordLabels carries dimensions discovered while a record is processed, such as its event type,
+// back up to the instrumentation that reports on the record once processing is done.
+type recordLabels struct {
+	mu        sync.Mutex
+	eventType string
+}
+
+type recordLabelsKey struct{}
+
+// ContextWithRecordLabels returns a copy of ctx that collects labels set further down the
+// processor chain, such as by SetEventType, so they can be read back from ctx afterwards.
+func ContextWithRecordLabels(ctx context.Context) context.Context {
+	return context.WithValue(ctx, recordLabelsKey{}, &recordLabels{})
+}
+
+// EventTypeUnknown is the metric dimension of event types that are not known to the processor.
+const EventTypeUnknown = "unknown"
+
+// SetEventType records the event type of the record being processed as a span attribute and,
+// if ctx was prepared with ContextWithRecordLabels, as a metric dimension. Event types come from
+// producers and are unbounded, so only known ones, such as those with a registered handler, are
+// used as a dimension; any other is recorded as EventTypeUnknown.
+func SetEventType(ctx context.Context, eventType string, known bool) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("messaging.event.type", eventType))
+	if labels, ok := ctx.Value(recordLabelsKey{}).(*recordLabels); ok {
+		if !known {
+			eventType = EventTypeUnknown
+		}
+		labels.mu.Lock()
+		labels.eventType = eventType
+		labels.mu.Unlock()
+	}
+}
+
+// EventTypeFromContext returns the metric dimension of the event type set with SetEventType, or
+// "" if there is none.
+func EventTypeFromContext(ctx context.Context) string {
+	labels, ok := ctx.Value(recordLabelsKey{}).(*recordLabels)
+	if !ok {
+		return ""
+	}
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+	return labels.eventType
+}
+
+// HeaderCorrelationID is the record header carrying the correlation id of a message flow.
+const HeaderCorrelationID = "correlation-id"
+
+type correlationIDKey struct{}
+
+// ContextWithCorrelationID returns a copy of ctx carrying a correlation id, to be propagated to the
+// records produced while handling it.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation id carried by ctx, or "" if there is none.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// CorrelationID returns the correlation id header of record, or "" if it has none.
+func CorrelationID(record *kgo.Record) string {
+	for _, h := range record.Headers {
+		if h.Key == HeaderCorrelationID {
+			return string(h.Value)
+		}
+	}
+	return ""
 }
 
 // Tracer returns a new tracer from the global tracer provider.
@@ -72,6 +481,7 @@ func Tracer() trace.Tracer {
 }
 
 // HeaderCarrier adapts kafka headers to the TextMapCarrier interface for propagation.
+// It only supports extraction; inject into a record with RecordCarrier.
 type HeaderCarrier []kgo.RecordHeader
 
 func (hc HeaderCarrier) Get(key string) string {
@@ -90,3 +500,28 @@ func (hc HeaderCarrier) Keys() []string {
 	}
 	return keys
 }
+
+// RecordCarrier adapts the headers of a record to the TextMapCarrier interface for injection,
+// e.g. propagator.Inject(ctx, RecordCarrier{Record: record}).
+type RecordCarrier struct {
+	Record *kgo.Record
+}
+
+func (rc RecordCarrier) Get(key string) string {
+	return HeaderCarrier(rc.Record.Headers).Get(key)
+}
+
+// Set replaces the value of the header key, or appends the header if there is none.
+func (rc RecordCarrier) Set(key, value string) {
+	for i, h := range rc.Record.Headers {
+		if h.Key == key {
+			rc.Record.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	rc.Record.Headers = append(rc.Record.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+}
+
+func (rc RecordCarrier) Keys() []string {
+	return HeaderCarrier(rc.Record.Headers).Keys()
+}